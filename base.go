@@ -13,6 +13,8 @@ import (
 	env "github.com/remerge/go-env"
 	lft "github.com/remerge/go-lock_free_timer"
 	"github.com/spf13/cobra"
+
+	"github.com/remerge/go-service/registry"
 )
 
 // Base provides common main service functionallity and can be embeded in a main service object.
@@ -25,6 +27,7 @@ import (
 // - a health checker (if requested)
 // - a rollbar instance (sends logged Errors to rollbar in production mode)
 // - a stackdriver connection to colelct ongoing profiles (if requested)
+// - a metrics pusher to a Prometheus Pushgateway (if configured, run by the runner of the registry)
 //
 // On startup Base checks if the previous shutdown was graceful.
 type Base struct {
@@ -44,13 +47,20 @@ type Base struct {
 
 	metricsRegistry *lft.Registry
 	promMetrics     *PrometheusMetrics
+	metricsPusher   *metricsPusher
 	closeChannel    chan struct{}
 }
 
+type baseParams struct {
+	registry.Params
+	Cmd    *cobra.Command
+	Runner *RunnerWithRegistry `registry:"optional"`
+}
+
 // RegisterBase registers a Base ctor with a given DI registry. Additonal it registers
 // the ctors for logger, metrics, debug forwarder, tracker, http server, stackdriver and debug http server.
 func RegisterBase(r Registry, name string) {
-	r.Register(func(cmd *cobra.Command) (*Base, error) {
+	r.Register(func(p *baseParams) (*Base, error) {
 
 		metricsRegistry := lft.DefaultRegistry
 		base := &Base{
@@ -60,6 +70,7 @@ func RegisterBase(r Registry, name string) {
 			promMetrics:     NewPrometheusMetrics(metricsRegistry, name),
 			closeChannel:    make(chan struct{}),
 		}
+		base.metricsPusher = newMetricsPusher(base.Log, base.promMetrics, name)
 
 		// until we correctly register metrics with the correct registry everywhere, sync
 		go func() {
@@ -68,7 +79,20 @@ func RegisterBase(r Registry, name string) {
 			}
 		}()
 
-		base.configureFlags(cmd)
+		base.configureFlags(p.Cmd)
+		if p.Runner != nil {
+			// added before Base, so it is shutdown after Base and all services added after it. Services
+			// added before Base or initialized in parallel (see ParallelInit) might be shutdown after
+			// the last push, the metrics they record during their shutdown are lost.
+			p.Runner.Add(base.metricsPusher)
+		}
+
+		// time all constructor calls of the registry
+		if tr, ok := r.(constructionTracer); ok {
+			tr.OnConstruction(func(c registry.Construction) {
+				recordConstruction(base.metricsRegistry, c)
+			})
+		}
 
 		r.Register(func() (cue.Logger, error) {
 			return base.Log, nil
@@ -82,8 +106,8 @@ func RegisterBase(r Registry, name string) {
 			return base.promMetrics, nil
 		})
 
-		r.Register(NewDefaultHealthCheckerService)
-		r.Register(NewTrackerService, name)
+		r.Register(newHealthCheckerService)
+		r.Register(NewTracker, name)
 		r.Register(newStackdriverService, name)
 		r.Register(newDebugForwader)
 		registerServer(r, name)
@@ -100,6 +124,38 @@ func (b *Base) configureFlags(cmd *cobra.Command) {
 		b.Rollbar.Token,
 		"rollbar token",
 	)
+	MarkSecret(cmd.Flags(), "rollbar-token")
+	cmd.Flags().BoolVar(
+		&b.promMetrics.NativeHistograms,
+		"metrics-native-histograms",
+		b.promMetrics.NativeHistograms,
+		"export all histograms with buckets as native prometheus histograms",
+	)
+	cmd.Flags().StringVar(
+		&b.metricsPusher.url,
+		"metrics-push-url",
+		b.metricsPusher.url,
+		"push metrics to the prometheus pushgateway at this url",
+	)
+	MarkSecret(cmd.Flags(), "metrics-push-url")
+	cmd.Flags().StringVar(
+		&b.metricsPusher.job,
+		"metrics-push-job",
+		b.metricsPusher.job,
+		"job name metrics are pushed with",
+	)
+	cmd.Flags().DurationVar(
+		&b.metricsPusher.interval,
+		"metrics-push-interval",
+		b.metricsPusher.interval,
+		"interval metrics are pushed in",
+	)
+	cmd.Flags().IntVar(
+		&b.metricsPusher.retries,
+		"metrics-push-retries",
+		b.metricsPusher.retries,
+		"number of retries of a failed metrics push",
+	)
 }
 
 func (b *Base) Init() error {
@@ -1,13 +1,17 @@
 package service
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 
 	env "github.com/remerge/go-env"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
+
+	"github.com/remerge/go-service/registry"
 )
 
 type InitFnc func(*RunnerWithRegistry)
@@ -18,6 +22,11 @@ var logLevelString string
 // - create a service registry and a runner
 // - registered all Base services
 // - creates the base cobra Cmd
+// - loads flags not given on the command line from the environment or a config file (see Config)
+// - reloads these flags on SIGHUP
+//
+// Services are initialized one after another in the order they are created. Services opt in to
+// parallel initialization by setting ParallelInit on the runner in initFnc before creating them.
 func Cmd(name string, initFnc InitFnc) *cobra.Command {
 	initLogCollector()
 	setLogLevelFrom(parseLogLevelFlat())
@@ -39,6 +48,21 @@ func Cmd(name string, initFnc InitFnc) *cobra.Command {
 		"environment to run in (development, test, production)",
 	)
 
+	config := NewConfig(name)
+	flags.StringVar(
+		&config.File,
+		"config", "",
+		fmt.Sprintf("config file (yaml, json or toml), defaults to $%s_CONFIG", config.EnvPrefix),
+	)
+	cmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if err := config.Load(cmd.Flags()); err != nil {
+			return err
+		}
+		// the log level might have been changed by the environment or config file
+		setLogLevelFrom(logLevelString)
+		return nil
+	}
+
 	// version command for deployment
 	cmd.AddCommand(&cobra.Command{
 		Use:   "version",
@@ -48,7 +72,46 @@ func Cmd(name string, initFnc InitFnc) *cobra.Command {
 		},
 	})
 
+	// print the effective configuration
+	cmd.AddCommand(configCmd(cmd, config))
+
 	r := NewRunnerWithRegistry()
+	cmd.Flags().DurationVar(
+		&r.LameDuckPeriod,
+		"lame-duck-period", r.LameDuckPeriod,
+		"time to keep serving with failing readiness after a shutdown signal",
+	)
+	// added first, so services see the reloaded flags in their own Reload
+	r.Add(&configService{Config: config, reloaded: func() { setLogLevelFrom(logLevelString) }})
+	registerCmd(r, cmd, config)
+	RegisterBase(r.Registry, name)
+	initFnc(r)
+
+	// print the registry graph, needs to be added after initFnc registered all providers
+	cmd.AddCommand(depsCmd(r.Registry))
+
+	cmd.SilenceUsage = true
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		// fail before any service is started if the registry can't satisfy all providers
+		if err := r.Validate(); err != nil {
+			return fmt.Errorf("invalid service registry: %v", err)
+		}
+		// services might create more values while they are initialized
+		postInit := r.PostInit
+		r.PostInit = func() {
+			if postInit != nil {
+				postInit()
+			}
+			logConstructions(r.log, r.Registry)
+		}
+		return r.Run()
+	}
+
+	return cmd
+}
+
+// registerCmd registers the runner itself, the command and its config with the registry of r
+func registerCmd(r *RunnerWithRegistry, cmd *cobra.Command, config *Config) {
 	// so services can register themselves for execution
 	r.Register(func() (*RunnerWithRegistry, error) {
 		return r, nil
@@ -56,17 +119,39 @@ func Cmd(name string, initFnc InitFnc) *cobra.Command {
 	r.Register(func() (*cobra.Command, error) {
 		return cmd, nil
 	})
-	RegisterBase(r.Registry, name)
-	initFnc(r)
+	r.Register(func() (*Config, error) {
+		return config, nil
+	})
+}
 
-	cmd.SilenceUsage = true
-	cmd.RunE = func(cmd *cobra.Command, args []string) error {
-		return r.Run()
+func depsCmd(r *registry.Registry) *cobra.Command {
+	var format string
+	cmd := &cobra.Command{
+		Use:   "deps",
+		Short: "display the service registry dependency graph and exit",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return writeRegistryGraph(cmd.OutOrStdout(), r, format)
+		},
 	}
-
+	cmd.Flags().StringVar(&format, "format", "dot", "output format (dot, json)")
 	return cmd
 }
 
+// writeRegistryGraph writes the provider graph of r in the given format (dot or json)
+func writeRegistryGraph(w io.Writer, r *registry.Registry, format string) error {
+	g := r.Graph()
+	switch format {
+	case "dot":
+		return g.WriteDOT(w)
+	case "json":
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(g)
+	default:
+		return fmt.Errorf("unknown graph format %q", format)
+	}
+}
+
 func parseLogLevelFlat() (level string) {
 	fs := pflag.NewFlagSet("log", pflag.ContinueOnError)
 	addLogFlag(fs, &level)
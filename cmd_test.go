@@ -0,0 +1,50 @@
+package service
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// argService is created with an argument passed to Create and stops the runner once it is initialized
+type argService struct {
+	r *Runner
+	n int
+}
+
+func (s *argService) Init() error {
+	s.r.Stop()
+	return nil
+}
+
+func (s *argService) Shutdown(os.Signal) {}
+
+func TestCmdWithRequestArgs(t *testing.T) {
+	var s *argService
+	cmd := Cmd("test", func(r *RunnerWithRegistry) {
+		r.PostShutdown = nil
+		_, err := r.Register(func(r *RunnerWithRegistry, n int) (*argService, error) {
+			return &argService{r: r.Runner, n: n}, nil
+		})
+		require.NoError(t, err)
+		r.Create(&s, 42)
+	})
+	cmd.SetArgs([]string{})
+	require.NoError(t, cmd.Execute())
+	require.Equal(t, 42, s.n)
+}
+
+func TestCmdKeepsPostInit(t *testing.T) {
+	var postInit bool
+	cmd := Cmd("test", func(r *RunnerWithRegistry) {
+		r.PostShutdown = nil
+		r.PostInit = func() {
+			postInit = true
+			r.Stop()
+		}
+	})
+	cmd.SetArgs([]string{})
+	require.NoError(t, cmd.Execute())
+	require.True(t, postInit)
+}
@@ -0,0 +1,386 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/BurntSushi/toml"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// FlagSource describes where the value of a flag came from
+type FlagSource string
+
+// Flag sources ordered by precedence, the first one wins
+const (
+	SourceFlag    FlagSource = "flag"
+	SourceEnv     FlagSource = "env"
+	SourceFile    FlagSource = "file"
+	SourceDefault FlagSource = "default"
+)
+
+// secretAnnotation marks flags whose values must not be shown
+const secretAnnotation = "go-service/secret"
+
+const maskedValue = "******"
+
+// MarkSecret marks the flag with the given name as secret. The value of a secret flag is masked
+// whenever the configuration is printed.
+func MarkSecret(flags *pflag.FlagSet, name string) {
+	if err := flags.SetAnnotation(name, secretAnnotation, []string{"true"}); err != nil {
+		panic(err)
+	}
+}
+
+// IsSecret returns true if the flag was marked with MarkSecret
+func IsSecret(f *pflag.Flag) bool {
+	_, ok := f.Annotations[secretAnnotation]
+	return ok
+}
+
+// Config binds an optional config file and environment variables to command line flags.
+// Values are taken with the precedence flag > env > file > default.
+//
+// The environment variable of a flag is the upper cased flag name prefixed with EnvPrefix and
+// dashes replaced by underscores, e.g. EXAMPLE_SERVER_PORT for server-port. The config file is
+// a YAML, JSON or TOML file (detected by extension) mapping flag names to values. Nested
+// sections are joined by dashes, so "server: {port: 8080}" sets server-port as well.
+type Config struct {
+	EnvPrefix string
+	File      string
+
+	mu      sync.RWMutex
+	flags   *pflag.FlagSet
+	sources map[string]FlagSource
+}
+
+// NewConfig creates a Config using the environment variable prefix derived from the service name
+func NewConfig(name string) *Config {
+	return &Config{
+		EnvPrefix: envName(name),
+		sources:   map[string]FlagSource{},
+	}
+}
+
+// Load sets all flags not given on the command line from the environment or the config file.
+// The config file is taken from File or the <EnvPrefix>_CONFIG environment variable.
+func (c *Config) Load(flags *pflag.FlagSet) error {
+	values, err := c.readValues(flags)
+	if err != nil {
+		return err
+	}
+
+	sources := map[string]FlagSource{}
+	flags.VisitAll(func(f *pflag.Flag) {
+		if err != nil {
+			return
+		}
+		if f.Changed {
+			sources[f.Name] = SourceFlag
+			return
+		}
+		value, source, ok := c.lookup(f.Name, values)
+		if ok {
+			if setErr := flags.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid value %q for flag %s from %s: %v", value, f.Name, source, setErr)
+				return
+			}
+		}
+		sources[f.Name] = source
+	})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.flags = flags
+	c.sources = sources
+	c.mu.Unlock()
+	return nil
+}
+
+// Reload re-reads the environment and the config file for the flags passed to Load, with the same
+// precedence. Flags given on the command line keep their value, flags no longer set in the environment
+// or the config file are reset to their default. If the config file can't be read or a value is invalid
+// all flags keep their previous value. List flags can't be changed by a reload, pflag only appends to them.
+// Reload is called by the Runner on SIGHUP if the Config was added by Cmd.
+func (c *Config) Reload() error {
+	c.mu.RLock()
+	flags, previous := c.flags, c.sources
+	c.mu.RUnlock()
+	if flags == nil {
+		return fmt.Errorf("config was not loaded")
+	}
+
+	values, err := c.readValues(flags)
+	if err != nil {
+		return err
+	}
+
+	sources := map[string]FlagSource{}
+	restore := map[*pflag.Flag]string{}
+	var changed []string
+	flags.VisitAll(func(f *pflag.Flag) {
+		if err != nil {
+			return
+		}
+		source, loaded := previous[f.Name]
+		if source == SourceFlag || (!loaded && f.Changed) {
+			sources[f.Name] = SourceFlag
+			return
+		}
+		value, source, ok := c.lookup(f.Name, values)
+		if !ok {
+			value = f.DefValue
+		}
+		old := f.Value.String()
+		if isListFlag(f) {
+			if "["+value+"]" != old {
+				NewLogger("config").Warnf("list flag %s can't be reloaded, keeping %s", f.Name, old)
+				source = previous[f.Name]
+			}
+			sources[f.Name] = source
+			return
+		}
+		// some values are changed even if Set fails
+		restore[f] = old
+		if setErr := f.Value.Set(value); setErr != nil {
+			err = fmt.Errorf("invalid value %q for flag %s from %s: %v", value, f.Name, source, setErr)
+			return
+		}
+		if f.Value.String() != old {
+			changed = append(changed, f.Name)
+		}
+		sources[f.Name] = source
+	})
+	if err != nil {
+		for f, old := range restore {
+			_ = f.Value.Set(old)
+		}
+		return err
+	}
+
+	c.mu.Lock()
+	c.sources = sources
+	c.mu.Unlock()
+	if len(changed) > 0 {
+		NewLogger("config").Infof("config reloaded, changed flags: %s", strings.Join(changed, ", "))
+	}
+	return nil
+}
+
+// readValues reads the config file, if there is one, and warns about values without a flag
+func (c *Config) readValues(flags *pflag.FlagSet) (map[string]string, error) {
+	file := c.File
+	if file == "" {
+		file = os.Getenv(c.envKey("config"))
+	}
+	if file == "" {
+		return map[string]string{}, nil
+	}
+
+	values, err := readConfigFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %v", file, err)
+	}
+
+	var unknown []string
+	for name := range values {
+		if flags.Lookup(name) == nil {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		NewLogger("config").Warnf("config file %s contains unknown flags: %s", file, strings.Join(unknown, ", "))
+	}
+	return values, nil
+}
+
+// lookup returns the value of the flag from the environment or the config file values
+func (c *Config) lookup(name string, values map[string]string) (string, FlagSource, bool) {
+	if value, ok := os.LookupEnv(c.envKey(name)); ok {
+		return value, SourceEnv, true
+	}
+	if value, ok := values[name]; ok {
+		return value, SourceFile, true
+	}
+	return "", SourceDefault, false
+}
+
+func isListFlag(f *pflag.Flag) bool {
+	t := f.Value.Type()
+	return strings.HasSuffix(t, "Slice") || strings.HasSuffix(t, "Array")
+}
+
+// configService runs a Config with the Runner, so it is reloaded on SIGHUP. It needs to be added before all
+// other services, then they see the reloaded flag values in their own Reload.
+type configService struct {
+	*Config
+	// reloaded is called after every successful reload
+	reloaded func()
+}
+
+func (s *configService) Init() error {
+	// the flags are loaded before the runner is started
+	return nil
+}
+
+func (s *configService) Shutdown(os.Signal) {}
+
+func (s *configService) Reload() error {
+	if err := s.Config.Reload(); err != nil {
+		return err
+	}
+	if s.reloaded != nil {
+		s.reloaded()
+	}
+	return nil
+}
+
+// Source returns where the value of the flag with the given name came from
+func (c *Config) Source(name string) FlagSource {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if source, ok := c.sources[name]; ok {
+		return source
+	}
+	return SourceDefault
+}
+
+// ConfigEntry is the effective configuration of a single flag
+type ConfigEntry struct {
+	Name    string
+	Value   string
+	Source  FlagSource
+	Changed bool
+}
+
+// Entries returns the effective configuration of all flags sorted by name. Values of secret flags
+// are masked.
+func (c *Config) Entries(flags *pflag.FlagSet) []ConfigEntry {
+	var entries []ConfigEntry
+	flags.VisitAll(func(f *pflag.Flag) {
+		value := f.Value.String()
+		if IsSecret(f) && value != "" {
+			value = maskedValue
+		}
+		entries = append(entries, ConfigEntry{
+			Name:    f.Name,
+			Value:   value,
+			Source:  c.Source(f.Name),
+			Changed: f.Changed,
+		})
+	})
+	return entries
+}
+
+// Print writes the effective configuration of all flags to w
+func (c *Config) Print(w io.Writer, flags *pflag.FlagSet) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FLAG\tVALUE\tSOURCE")
+	for _, e := range c.Entries(flags) {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", e.Name, e.Value, e.Source)
+	}
+	return tw.Flush()
+}
+
+func (c *Config) envKey(flag string) string {
+	return envName(c.EnvPrefix + "_" + flag)
+}
+
+// configCmd creates the config subcommand printing the effective configuration of root. It parses
+// the flags of root itself so they can be passed to the subcommand.
+func configCmd(root *cobra.Command, c *Config) *cobra.Command {
+	return &cobra.Command{
+		Use:                "config",
+		Short:              "display the effective configuration and exit",
+		DisableFlagParsing: true,
+		// the flags are loaded in RunE
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error { return nil },
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := root.ParseFlags(args); err != nil {
+				return err
+			}
+			if err := c.Load(root.Flags()); err != nil {
+				return err
+			}
+			return c.Print(cmd.OutOrStdout(), root.Flags())
+		},
+	}
+}
+
+func envName(s string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(s))
+}
+
+// readConfigFile reads a YAML, JSON or TOML file into a flat map of flag names to values
+func readConfigFile(file string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(file) // #nosec
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]interface{}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".json":
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.UseNumber()
+		err = d.Decode(&raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("unsupported config file type %q", filepath.Ext(file))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	flattenConfig(values, "", raw)
+	return values, nil
+}
+
+func flattenConfig(values map[string]string, prefix string, v interface{}) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, e := range t {
+			flattenConfig(values, configKey(prefix, k), e)
+		}
+	case map[interface{}]interface{}:
+		for k, e := range t {
+			flattenConfig(values, configKey(prefix, fmt.Sprint(k)), e)
+		}
+	case []interface{}:
+		elems := make([]string, len(t))
+		for i, e := range t {
+			elems[i] = fmt.Sprint(e)
+		}
+		values[prefix] = strings.Join(elems, ",")
+	case nil:
+		values[prefix] = ""
+	default:
+		values[prefix] = fmt.Sprint(t)
+	}
+}
+
+func configKey(prefix, key string) string {
+	key = strings.Replace(key, "_", "-", -1)
+	if prefix == "" {
+		return key
+	}
+	return prefix + "-" + key
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/require"
+)
+
+type testConfigFlags struct {
+	port    int
+	host    string
+	timeout time.Duration
+	tags    []string
+	token   string
+}
+
+func newTestConfigFlags() (*pflag.FlagSet, *testConfigFlags) {
+	v := &testConfigFlags{}
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.IntVar(&v.port, "server-port", 80, "")
+	flags.StringVar(&v.host, "server-host", "localhost", "")
+	flags.DurationVar(&v.timeout, "timeout", time.Second, "")
+	flags.StringSliceVar(&v.tags, "tags", nil, "")
+	flags.StringVar(&v.token, "token", "", "")
+	MarkSecret(flags, "token")
+	return flags, v
+}
+
+func writeConfigFile(t *testing.T, ext, content string) string {
+	f, err := ioutil.TempFile("", "config*"+ext)
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = f.WriteString(content)
+	require.NoError(t, err)
+	return f.Name()
+}
+
+func TestConfigFileFormats(t *testing.T) {
+	files := map[string]string{
+		".yaml": "server:\n  port: 8080\ntimeout: 5s\ntags: [a, b]\n",
+		".json": `{"server": {"port": 8080}, "timeout": "5s", "tags": ["a", "b"]}`,
+		".toml": "timeout = \"5s\"\ntags = [\"a\", \"b\"]\n[server]\nport = 8080\n",
+	}
+	for ext, content := range files {
+		t.Run(ext, func(t *testing.T) {
+			flags, v := newTestConfigFlags()
+			c := NewConfig("test")
+			c.File = writeConfigFile(t, ext, content)
+			defer os.Remove(c.File)
+			require.NoError(t, c.Load(flags))
+
+			require.Equal(t, 8080, v.port)
+			require.Equal(t, 5*time.Second, v.timeout)
+			require.Equal(t, []string{"a", "b"}, v.tags)
+			require.Equal(t, "localhost", v.host)
+			require.Equal(t, SourceFile, c.Source("server-port"))
+			require.Equal(t, SourceDefault, c.Source("server-host"))
+		})
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	os.Setenv("TEST_SERVER_PORT", "9090")
+	os.Setenv("TEST_SERVER_HOST", "example.com")
+	defer os.Unsetenv("TEST_SERVER_PORT")
+	defer os.Unsetenv("TEST_SERVER_HOST")
+
+	flags, v := newTestConfigFlags()
+	require.NoError(t, flags.Parse([]string{"--server-host", "remerge.io"}))
+	c := NewConfig("test")
+	c.File = writeConfigFile(t, ".yml", "server-port: 8080\ntimeout: 5s\n")
+	defer os.Remove(c.File)
+	require.NoError(t, c.Load(flags))
+
+	require.Equal(t, "remerge.io", v.host)
+	require.Equal(t, SourceFlag, c.Source("server-host"))
+	require.Equal(t, 9090, v.port)
+	require.Equal(t, SourceEnv, c.Source("server-port"))
+	require.Equal(t, 5*time.Second, v.timeout)
+	require.Equal(t, SourceFile, c.Source("timeout"))
+	require.Equal(t, SourceDefault, c.Source("tags"))
+}
+
+func TestConfigFileFromEnv(t *testing.T) {
+	file := writeConfigFile(t, ".json", `{"server-port": 1234}`)
+	defer os.Remove(file)
+	os.Setenv("TEST_CONFIG", file)
+	defer os.Unsetenv("TEST_CONFIG")
+
+	flags, v := newTestConfigFlags()
+	require.NoError(t, NewConfig("test").Load(flags))
+	require.Equal(t, 1234, v.port)
+}
+
+func TestConfigInvalidValue(t *testing.T) {
+	flags, _ := newTestConfigFlags()
+	c := NewConfig("test")
+	c.File = writeConfigFile(t, ".yaml", "server-port: abc\n")
+	defer os.Remove(c.File)
+	require.Error(t, c.Load(flags))
+
+	c.File = writeConfigFile(t, ".ini", "server-port=1\n")
+	defer os.Remove(c.File)
+	require.EqualError(t, c.Load(flags), `failed to read config file `+c.File+`: unsupported config file type ".ini"`)
+}
+
+func TestConfigPrintMasksSecrets(t *testing.T) {
+	os.Setenv("TEST_TOKEN", "secret")
+	defer os.Unsetenv("TEST_TOKEN")
+
+	flags, _ := newTestConfigFlags()
+	c := NewConfig("test")
+	require.NoError(t, c.Load(flags))
+
+	var b bytes.Buffer
+	require.NoError(t, c.Print(&b, flags))
+	require.NotContains(t, b.String(), "secret")
+	require.Contains(t, b.String(), "token        ******     env")
+}
+
+func TestConfigEntries(t *testing.T) {
+	os.Setenv("TEST_TIMEOUT", "1m")
+	defer os.Unsetenv("TEST_TIMEOUT")
+
+	flags, _ := newTestConfigFlags()
+	require.NoError(t, flags.Parse([]string{"--server-port", "81", "--token", "secret"}))
+	c := NewConfig("test")
+	require.NoError(t, c.Load(flags))
+
+	require.Equal(t, []ConfigEntry{
+		{Name: "server-host", Value: "localhost", Source: SourceDefault},
+		{Name: "server-port", Value: "81", Source: SourceFlag, Changed: true},
+		{Name: "tags", Value: "[]", Source: SourceDefault},
+		{Name: "timeout", Value: "1m0s", Source: SourceEnv, Changed: true},
+		{Name: "token", Value: "******", Source: SourceFlag, Changed: true},
+	}, c.Entries(flags))
+}
+
+func TestConfigReload(t *testing.T) {
+	os.Setenv("TEST_SERVER_HOST", "example.com")
+	defer os.Unsetenv("TEST_SERVER_HOST")
+
+	flags, v := newTestConfigFlags()
+	require.NoError(t, flags.Parse([]string{"--token", "secret"}))
+	c := NewConfig("test")
+	c.File = writeConfigFile(t, ".yaml", "server-port: 8080\ntimeout: 5s\ntoken: other\n")
+	defer os.Remove(c.File)
+	require.NoError(t, c.Load(flags))
+
+	require.NoError(t, ioutil.WriteFile(c.File, []byte("server-port: 9090\ntoken: other\n"), 0600))
+	os.Setenv("TEST_SERVER_HOST", "remerge.io")
+	require.NoError(t, c.Reload())
+	require.Equal(t, 9090, v.port)
+	require.Equal(t, "remerge.io", v.host)
+	require.Equal(t, SourceEnv, c.Source("server-host"))
+	// removed values are reset to their default, flags given on the command line are kept
+	require.Equal(t, time.Second, v.timeout)
+	require.Equal(t, SourceDefault, c.Source("timeout"))
+	require.Equal(t, "secret", v.token)
+	require.Equal(t, SourceFlag, c.Source("token"))
+
+	// an invalid value keeps the previous config
+	require.NoError(t, ioutil.WriteFile(c.File, []byte("server-port: 7070\ntimeout: abc\n"), 0600))
+	require.Error(t, c.Reload())
+	require.Equal(t, 9090, v.port)
+	require.Equal(t, time.Second, v.timeout)
+	require.Equal(t, SourceFile, c.Source("server-port"))
+
+	require.NoError(t, os.Remove(c.File))
+	require.Error(t, c.Reload())
+	require.Equal(t, 9090, v.port)
+}
+
+// portService reports the port it sees on every reload
+type portService struct {
+	port  *int
+	ports chan int
+}
+
+func (s *portService) Init() error        { return nil }
+func (s *portService) Shutdown(os.Signal) {}
+func (s *portService) Reload() error      { s.ports <- *s.port; return nil }
+
+func TestConfigReloadOnSIGHUP(t *testing.T) {
+	flags, v := newTestConfigFlags()
+	c := NewConfig("test")
+	c.File = writeConfigFile(t, ".yaml", "server-port: 8080\n")
+	defer os.Remove(c.File)
+	require.NoError(t, c.Load(flags))
+
+	config := NewRunnerDefaultConfig()
+	config.PostShutdown = nil
+	r := NewRunnerWithConfig(config)
+	r.Add(&configService{Config: c})
+	s := &portService{port: &v.port, ports: make(chan int, 1)}
+	r.Add(s)
+
+	done := make(chan error)
+	go func() { done <- r.Run() }()
+	waitForState(t, r, StateInitialized)
+
+	require.NoError(t, ioutil.WriteFile(c.File, []byte("server-port: 9090\n"), 0600))
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	select {
+	case port := <-s.ports:
+		require.Equal(t, 9090, port)
+	case <-time.After(time.Second):
+		t.Fatal("service was not reloaded")
+	}
+
+	r.Stop()
+	require.NoError(t, awaitRun(t, done))
+	// the reload is complete once the runner handled the stop
+	report := r.LastReload()
+	require.Len(t, report.Results, 2)
+	require.Equal(t, "service.configService", report.Results[0].Service)
+	require.Empty(t, report.Results[0].Error)
+}
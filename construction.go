@@ -0,0 +1,68 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/remerge/cue"
+
+	"github.com/remerge/go-service/registry"
+)
+
+// slowestConstructions is the number of constructor calls listed in the startup report
+const slowestConstructions = 5
+
+// constructionTracer is implemented by registries that report their constructor calls
+type constructionTracer interface {
+	OnConstruction(func(registry.Construction))
+}
+
+// recordConstruction records the duration of a constructor call in a timer and failed calls in a
+// counter, both labeled with the provider
+func recordConstruction(mr metrics.Registry, c registry.Construction) {
+	provider := Label{"provider", constructionName(c)}
+	metrics.GetOrRegisterTimer(DescribeMetric(MetricName("go_service", "registry_construction", provider),
+		"Time spent in registry constructors", "nanoseconds"), mr).Update(c.Took)
+	if c.Error != "" {
+		metrics.GetOrRegisterCounter(DescribeMetric(MetricName("go_service", "registry_construction_errors", provider),
+			"Number of failed registry constructor calls", ""), mr).Inc(1)
+	}
+}
+
+// logConstructions logs the total time spent in constructors of r and the slowest constructor calls
+func logConstructions(log cue.Logger, r *registry.Registry) {
+	constructions := r.Constructions()
+	if len(constructions) == 0 {
+		return
+	}
+	count := len(constructions)
+	var total time.Duration
+	for _, c := range constructions {
+		total += c.Took
+	}
+	sort.SliceStable(constructions, func(i, j int) bool {
+		return constructions[i].Took > constructions[j].Took
+	})
+	if len(constructions) > slowestConstructions {
+		constructions = constructions[:slowestConstructions]
+	}
+	var slowest []string
+	for _, c := range constructions {
+		slowest = append(slowest, fmt.Sprintf("%s=%v", constructionName(c), c.Took))
+	}
+	log.WithFields(cue.Fields{
+		"constructions": count,
+		"took":          total,
+		"slowest":       strings.Join(slowest, ","),
+	}).Info("registry constructions")
+}
+
+func constructionName(c registry.Construction) string {
+	if c.Name == "" {
+		return c.Provides
+	}
+	return fmt.Sprintf("%s[%s]", c.Provides, c.Name)
+}
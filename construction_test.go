@@ -0,0 +1,25 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/require"
+
+	"github.com/remerge/go-service/registry"
+)
+
+func TestRecordConstruction(t *testing.T) {
+	mr := metrics.NewRegistry()
+	recordConstruction(mr, registry.Construction{Provides: "*service.Server", Name: "admin", Took: time.Millisecond})
+	recordConstruction(mr, registry.Construction{Provides: "*service.Server", Name: "admin", Took: time.Millisecond, Error: "failed"})
+
+	provider := Label{"provider", "*service.Server[admin]"}
+	timer, ok := mr.Get(MetricName("go_service", "registry_construction", provider)).(metrics.Timer)
+	require.True(t, ok)
+	require.Equal(t, int64(2), timer.Count())
+	errors, ok := mr.Get(MetricName("go_service", "registry_construction_errors", provider)).(metrics.Counter)
+	require.True(t, ok)
+	require.Equal(t, int64(1), errors.Count())
+}
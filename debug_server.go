@@ -22,8 +22,15 @@ import (
 // - /meta for service metadata
 // - /pprof for go profiling
 // - /blockprof to configure the rate for conntention profiling
-// - /metrics for prometehus metrics
+// - /metrics for prometehus metrics, in the OpenMetrics format if preferred by the Accept header
 // - /panic to trigger a panic ;-)
+// - /healthcheck for the last health report
+// - /live for liveness probes
+// - /ready for readiness probes, see readyChecks
+// - /reload for the result of the last reload of the config and all Reloadable services, POST to trigger a reload
+// - /config for the effective configuration, secret flags are masked (see MarkSecret)
+// - /deps for the registry dependency graph, use ?format=json for JSON instead of DOT
+// - /constructions for the timing of all registry constructor calls
 
 type debugServer struct {
 	*Server
@@ -32,6 +39,15 @@ type debugServer struct {
 	serviceStartTime  time.Time
 	healthReportCache *HealthReportCache
 	healthChecker     *HealthChecker
+	runner            *Runner
+	registry          *registry.Registry
+	cmd               *cobra.Command
+	config            *Config
+
+	// readyChecks are the health checks that need to pass for the service to be ready. If empty all checks
+	// need to pass. The runner check is always required so readiness is lost as soon as the runner shuts down.
+	readyChecks []string
+	readiness   *HealthReportEvaluator
 }
 
 type debugServerParams struct {
@@ -42,6 +58,8 @@ type debugServerParams struct {
 	MetricsRegistry metrics.Registry
 	PromMetrics     *PrometheusMetrics
 	HealthChecker   *HealthChecker
+	Runner          *RunnerWithRegistry `registry:"optional"`
+	Config          *Config             `registry:"optional"`
 }
 
 type DebugEngine struct {
@@ -61,8 +79,14 @@ func registerDebugServer(r Registry, name string) {
 			metricsRegistry:   p.MetricsRegistry,
 			promMetrics:       p.PromMetrics,
 			healthChecker:     p.HealthChecker,
+			cmd:               p.Cmd,
+			config:            p.Config,
 			healthReportCache: NewHealthReportCache(CodeVersion),
 		}
+		if p.Runner != nil {
+			f.runner = p.Runner.Runner
+			f.registry = p.Runner.Registry
+		}
 		f.healthChecker.AddListener(f.healthReportCache)
 		f.configureFlags(p.Cmd)
 		return f, nil
@@ -80,6 +104,11 @@ func (s *debugServer) configureFlags(cmd *cobra.Command) {
 		"HTTP debug server port",
 	)
 
+	flags.StringSliceVar(
+		&s.readyChecks,
+		"server-debug-ready-checks", s.readyChecks,
+		"health checks required to pass for the /ready endpoint (default all)",
+	)
 }
 
 func (s *debugServer) Init() error {
@@ -87,6 +116,13 @@ func (s *debugServer) Init() error {
 		return err
 	}
 
+	if len(s.readyChecks) > 0 {
+		s.readiness = NewHealthReportEvaluator(append(s.readyChecks, RunnerHealthCheckName)...)
+	} else {
+		s.readiness = NewAllChecksHealthReportEvaluator()
+	}
+	s.healthChecker.AddListener(s.readiness)
+
 	s.serviceStartTime = time.Now()
 	go s.serveDebug()
 	return nil
@@ -98,6 +134,16 @@ func (s *debugServer) Shutdown(sig os.Signal) {
 }
 
 func (s *debugServer) serveDebug() {
+	s.routes()
+
+	s.log.WithFields(cue.Fields{
+		"port": s.Port,
+	}).Info("start debug server")
+
+	s.Serve(nil)
+}
+
+func (s *debugServer) routes() {
 	s.Engine.GET("/vars", gin.WrapH(exp.ExpHandler(s.metricsRegistry))) // expvar & go-metrics
 	s.Engine.GET("/pprof/", gin.WrapF(pprof.Index))
 	s.Engine.GET("/pprof/block", gin.WrapH(pprof.Handler("block")))
@@ -139,8 +185,8 @@ func (s *debugServer) serveDebug() {
 	})
 
 	s.Engine.GET("/metrics", func(c *gin.Context) {
-		c.Header("Content-Type", "text/plain; version=0.0.4")
-		c.String(http.StatusOK, s.promMetrics.String())
+		contentType, body := s.promMetrics.Negotiate(c.GetHeader("Accept"))
+		c.Data(http.StatusOK, contentType, []byte(body))
 	})
 
 	s.Engine.GET("/meta", func(c *gin.Context) {
@@ -156,9 +202,75 @@ func (s *debugServer) serveDebug() {
 		c.JSON(200, s.healthReportCache.State())
 	})
 
-	s.log.WithFields(cue.Fields{
-		"port": s.Port,
-	}).Info("start debug server")
+	s.Engine.GET("/live", func(c *gin.Context) {
+		c.JSON(http.StatusOK, map[string]interface{}{
+			"service": s.Name,
+			"version": CodeVersion,
+		})
+	})
 
-	s.Serve(nil)
+	s.Engine.GET("/ready", func(c *gin.Context) {
+		s.healthChecker.Update() // force an update
+		status := http.StatusOK
+		if !s.readiness.AllHealthy() {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, s.healthReportCache.State())
+	})
+
+	s.Engine.GET("/reload", func(c *gin.Context) {
+		if s.runner == nil {
+			c.String(http.StatusNotFound, "no runner")
+			return
+		}
+		report := s.runner.LastReload()
+		if report == nil {
+			c.String(http.StatusNotFound, "no reload yet")
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	})
+
+	s.Engine.POST("/reload", func(c *gin.Context) {
+		if s.runner == nil {
+			c.String(http.StatusNotFound, "no runner")
+			return
+		}
+		s.runner.Reload()
+		c.String(http.StatusAccepted, "reload triggered")
+	})
+
+	s.Engine.GET("/config", func(c *gin.Context) {
+		if s.config == nil {
+			c.String(http.StatusNotFound, "no config")
+			return
+		}
+		c.JSON(http.StatusOK, s.config.Entries(s.cmd.Flags()))
+	})
+
+	s.Engine.GET("/constructions", func(c *gin.Context) {
+		if s.registry == nil {
+			c.String(http.StatusNotFound, "no registry")
+			return
+		}
+		c.JSON(http.StatusOK, s.registry.Constructions())
+	})
+
+	s.Engine.GET("/deps", func(c *gin.Context) {
+		if s.registry == nil {
+			c.String(http.StatusNotFound, "no registry")
+			return
+		}
+		format := c.DefaultQuery("format", "dot")
+		switch format {
+		case "dot":
+			c.Header("Content-Type", "text/vnd.graphviz")
+		case "json":
+			c.Header("Content-Type", "application/json")
+		default:
+			c.String(http.StatusBadRequest, "unknown graph format %q", format)
+			return
+		}
+		_ = writeRegistryGraph(c.Writer, s.registry, format)
+	})
 }
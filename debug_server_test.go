@@ -0,0 +1,59 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDebugServer(t *testing.T, s *debugServer) *debugServer {
+	s.Server = &Server{Name: "test", log: NewLogger("test")}
+	require.NoError(t, s.Server.Init())
+	s.routes()
+	return s
+}
+
+func debugRequest(s *debugServer, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.Engine.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return w
+}
+
+func TestDebugServerWithoutOptionalParams(t *testing.T) {
+	s := newTestDebugServer(t, &debugServer{})
+	require.Equal(t, http.StatusNotFound, debugRequest(s, http.MethodGet, "/reload").Code)
+	require.Equal(t, http.StatusNotFound, debugRequest(s, http.MethodPost, "/reload").Code)
+	require.Equal(t, http.StatusNotFound, debugRequest(s, http.MethodGet, "/constructions").Code)
+	require.Equal(t, http.StatusNotFound, debugRequest(s, http.MethodGet, "/deps").Code)
+	require.Equal(t, http.StatusNotFound, debugRequest(s, http.MethodGet, "/config").Code)
+}
+
+func TestDebugServerReload(t *testing.T) {
+	r := NewRunnerWithRegistry()
+	s := newTestDebugServer(t, &debugServer{runner: r.Runner, registry: r.Registry})
+	require.Equal(t, http.StatusNotFound, debugRequest(s, http.MethodGet, "/reload").Code)
+	require.Equal(t, http.StatusAccepted, debugRequest(s, http.MethodPost, "/reload").Code)
+	require.Equal(t, http.StatusOK, debugRequest(s, http.MethodGet, "/constructions").Code)
+}
+
+func TestDebugServerConfigMasksSecrets(t *testing.T) {
+	cmd := &cobra.Command{}
+	flags, _ := newTestConfigFlags()
+	cmd.Flags().AddFlagSet(flags)
+	require.NoError(t, cmd.Flags().Parse([]string{"--token", "secret"}))
+	c := NewConfig("test")
+	require.NoError(t, c.Load(cmd.Flags()))
+
+	s := newTestDebugServer(t, &debugServer{cmd: cmd, config: c})
+	w := debugRequest(s, http.MethodGet, "/config")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NotContains(t, w.Body.String(), "secret")
+
+	var entries []ConfigEntry
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &entries))
+	require.Contains(t, entries, ConfigEntry{Name: "token", Value: "******", Source: SourceFlag, Changed: true})
+}
@@ -58,7 +58,7 @@ func ginRecovery(name string) gin.HandlerFunc {
 	}
 }
 
-func ginRequestsWaiter(name string, wg *sync.WaitGroup, closing *uint32) gin.HandlerFunc {
+func ginRequestsWaiter(name string, wg *sync.WaitGroup, closing, draining *uint32) gin.HandlerFunc {
 	log := NewLogger(name)
 	return func(c *gin.Context) {
 		if atomic.LoadUint32(closing) == 1 {
@@ -66,6 +66,9 @@ func ginRequestsWaiter(name string, wg *sync.WaitGroup, closing *uint32) gin.Han
 			c.AbortWithStatus(http.StatusServiceUnavailable)
 			return
 		}
+		if atomic.LoadUint32(draining) == 1 {
+			c.Header("Connection", "close")
+		}
 		wg.Add(1)
 		c.Next()
 		wg.Done()
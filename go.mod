@@ -2,6 +2,7 @@ module github.com/remerge/go-service
 
 require (
 	cloud.google.com/go v0.56.0
+	github.com/BurntSushi/toml v0.3.1
 	github.com/Shopify/sarama v1.24.1
 	github.com/d4l3k/messagediff v1.2.1
 	github.com/eapache/go-resiliency v1.2.0 // indirect
@@ -29,6 +30,7 @@ require (
 	google.golang.org/grpc v1.29.1 // indirect
 	gopkg.in/go-playground/assert.v1 v1.2.1 // indirect
 	gopkg.in/go-playground/validator.v8 v8.18.2 // indirect
+	gopkg.in/yaml.v2 v2.3.0
 )
 
 go 1.13
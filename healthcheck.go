@@ -9,6 +9,8 @@ import (
 
 	"github.com/rcrowley/go-metrics"
 	"github.com/remerge/cue"
+
+	"github.com/remerge/go-service/registry"
 )
 
 // HealthCheckable is a subject which's health can be checked
@@ -22,6 +24,13 @@ type HealthCheckRegistry interface {
 	})
 }
 
+// HealthCheck is a named HealthCheckable. Constructors returning a *HealthCheck can be registered
+// with RegisterGroup to add the check to the default HealthChecker.
+type HealthCheck struct {
+	Name string
+	HealthCheckable
+}
+
 // CheckHealth can be used to wrap functions so they fulfill the HealthCheckable interface
 type CheckHealth func() error
 
@@ -30,10 +39,12 @@ func (f CheckHealth) Healthy() error { return f() }
 type HealthReport map[string]HealthCheckResult
 
 // HealthCheckResult is the result of a single check
-// It contains the duration since the check is in a health state. If it is not healthy Error is set
+// It contains the duration since the check is in a health state. If it is not healthy Error is set.
+// State is set for checks of services run by a Runner and contains their lifecycle state.
 type HealthCheckResult struct {
 	HealthyFor time.Duration `json:"Age,omitempty"` // was age
 	Error      string        `json:",omitempty"`
+	State      string        `json:",omitempty"`
 }
 
 // HealthReportListener are notified via HealthReportPublished whenever a new HealthReport is available
@@ -58,13 +69,39 @@ type HealthChecker struct {
 }
 
 // NewDefaultHealthCheckerService calls NewDefaultHealthChecker and registers the Healthchecker as a service with a runner
-// so it is started/stopped.
+// so it is started/stopped. The runner registers a health check for each of its services with the HealthChecker.
+// The HealthChecker is only run once if it is created by the registry as well (see NewRunnerWithRegistry).
 func NewDefaultHealthCheckerService(r *RunnerWithRegistry, mr metrics.Registry) (*HealthChecker, error) {
 	hc, err := NewDefaultHealthChecker(mr)
 	if err != nil {
 		return nil, err
 	}
 	r.Add(hc)
+	r.UseHealthChecks(hc)
+	return hc, nil
+}
+
+type healthCheckerParams struct {
+	registry.Params
+	Runner          *RunnerWithRegistry
+	MetricsRegistry metrics.Registry
+	Checks          []*HealthCheck         `registry:"group"`
+	Listeners       []HealthReportListener `registry:"group"`
+}
+
+// newHealthCheckerService creates the default HealthChecker service including all checks and listeners
+// registered as group providers
+func newHealthCheckerService(p *healthCheckerParams) (*HealthChecker, error) {
+	hc, err := NewDefaultHealthCheckerService(p.Runner, p.MetricsRegistry)
+	if err != nil {
+		return nil, err
+	}
+	for _, check := range p.Checks {
+		hc.AddCheck(check.Name, check)
+	}
+	for _, l := range p.Listeners {
+		hc.AddListener(l)
+	}
 	return hc, nil
 }
 
@@ -90,6 +127,8 @@ func NewHealthChecker(version string, pollInterval time.Duration, registry metri
 }
 
 func (h *HealthChecker) AddListener(l HealthReportListener) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 	h.listeners = append(h.listeners, l)
 }
 
@@ -185,9 +224,11 @@ type healthcheckEvaluator struct {
 }
 
 func newHealthcheckEvaluator(registry metrics.Registry, name, version string, checkable HealthCheckable) (e *healthcheckEvaluator) {
+	health := DescribeMetric(MetricName("go_service", "health", Label{"name", name}, Label{"version", version}),
+		"Time the service has been healthy for, 0 if unhealthy", "nanoseconds")
 	e = &healthcheckEvaluator{
 		checkable:            checkable,
-		healthyDurationGauge: metrics.GetOrRegisterGauge(fmt.Sprintf("go_service,name=%s,version=%s health", name, version), registry),
+		healthyDurationGauge: metrics.GetOrRegisterGauge(health, registry),
 		healthySince:         time.Now(),
 		failed:               true, // not evaluated yet
 	}
@@ -195,25 +236,25 @@ func newHealthcheckEvaluator(registry metrics.Registry, name, version string, ch
 }
 
 func (e *healthcheckEvaluator) evaluate(now time.Time) (s HealthCheckResult) {
+	if l, ok := e.checkable.(LifecycleReporter); ok {
+		s.State = l.LifecycleState().String()
+	}
 	if err := e.checkable.Healthy(); err != nil {
 		if !e.failed {
 			e.healthySince = now
 			e.failed = true
 		}
 		e.healthyDurationGauge.Update(0)
-		return HealthCheckResult{
-			Error: fmt.Sprint(err),
-		}
+		s.Error = fmt.Sprint(err)
+		return s
 	}
 	if e.failed {
 		e.healthySince = now
 		e.failed = false
 	}
-	healthyFor := now.Sub(e.healthySince)
-	e.healthyDurationGauge.Update(int64(healthyFor))
-	return HealthCheckResult{
-		HealthyFor: healthyFor,
-	}
+	s.HealthyFor = now.Sub(e.healthySince)
+	e.healthyDurationGauge.Update(int64(s.HealthyFor))
+	return s
 }
 
 // HealthReportLogger generates a log message per health check if its status (healthy/unhealthy) has changed compared to the last time
@@ -287,8 +328,9 @@ func (c *HealthReportCache) State() map[string]interface{} {
 // HealthReportEvaluator analyses a HealthReport and compares the result of checks aginst a given set of checks that need  to pass.
 // If one of the checks did not pass AllHealthy will return false.
 type HealthReportEvaluator struct {
-	required []string
-	failed   uint32
+	required   []string
+	requireAll bool
+	failed     uint32
 }
 
 func NewHealthReportEvaluator(required ...string) *HealthReportEvaluator {
@@ -298,6 +340,14 @@ func NewHealthReportEvaluator(required ...string) *HealthReportEvaluator {
 	}
 }
 
+// NewAllChecksHealthReportEvaluator creates a HealthReportEvaluator that requires every check of a HealthReport to pass.
+func NewAllChecksHealthReportEvaluator() *HealthReportEvaluator {
+	return &HealthReportEvaluator{
+		requireAll: true,
+		failed:     1,
+	}
+}
+
 func (h *HealthReportEvaluator) HealthReportPublished(_ time.Time, report HealthReport) {
 	var v uint32
 	for _, name := range h.required {
@@ -307,6 +357,14 @@ func (h *HealthReportEvaluator) HealthReportPublished(_ time.Time, report Health
 			break
 		}
 	}
+	if h.requireAll {
+		for _, res := range report {
+			if res.Error != "" {
+				v = 1
+				break
+			}
+		}
+	}
 	atomic.StoreUint32(&h.failed, v)
 }
 
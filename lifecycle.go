@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// ServiceState describes where a service added to a Runner is in its lifecycle
+type ServiceState int32
+
+// lifecycle states of a service
+const (
+	StatePending ServiceState = iota
+	StateInitializing
+	StateInitialized
+	StateDraining
+	StateInitFailed
+	StateShuttingDown
+	StateShutdown
+)
+
+func (s ServiceState) String() string {
+	switch s {
+	case StatePending:
+		return "pending"
+	case StateInitializing:
+		return "initializing"
+	case StateInitialized:
+		return "initialized"
+	case StateDraining:
+		return "draining"
+	case StateInitFailed:
+		return "init failed"
+	case StateShuttingDown:
+		return "shutting down"
+	case StateShutdown:
+		return "shutdown"
+	}
+	return fmt.Sprintf("unknown(%d)", int32(s))
+}
+
+type signalKey struct{}
+
+func withSignal(ctx context.Context, sig os.Signal) context.Context {
+	return context.WithValue(ctx, signalKey{}, sig)
+}
+
+// SignalFromContext returns the signal that caused a shutdown from the context passed to ContextService.Stop.
+// It returns nil for a normal termination.
+func SignalFromContext(ctx context.Context) os.Signal {
+	sig, _ := ctx.Value(signalKey{}).(os.Signal)
+	return sig
+}
+
+// serviceAdapter adapts a Service to the ContextService interface
+type serviceAdapter struct {
+	Service
+}
+
+func (a serviceAdapter) Start(context.Context) error {
+	return a.Init()
+}
+
+func (a serviceAdapter) Stop(ctx context.Context) error {
+	a.Shutdown(SignalFromContext(ctx))
+	return nil
+}
+
+// LifecycleReporter is implemented by health checks that report the lifecycle state of a service.
+// The state is added to the HealthCheckResult of the check.
+type LifecycleReporter interface {
+	LifecycleState() ServiceState
+}
+
+func (s *runnable) setState(state ServiceState) {
+	atomic.StoreInt32((*int32)(&s.state), int32(state))
+}
+
+// LifecycleState returns the current lifecycle state of the service
+func (s *runnable) LifecycleState() ServiceState {
+	return ServiceState(atomic.LoadInt32((*int32)(&s.state)))
+}
+
+// Healthy reports an error as long as the service is not initialized. Afterwards the result of the services
+// own health check is returned if the service implements HealthCheckable.
+func (s *runnable) Healthy() error {
+	if state := s.LifecycleState(); state != StateInitialized {
+		return fmt.Errorf("service is %s", state)
+	}
+	if c, ok := s.svc.(HealthCheckable); ok {
+		return c.Healthy()
+	}
+	return nil
+}
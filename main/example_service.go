@@ -27,13 +27,12 @@ func Init(r *service.RunnerWithRegistry) {
 	// without any requirements:
 	// s := &ExampleService{}
 
-	s := service.MustCreate(r.Register(func(cmd *cobra.Command) (*ExampleService, error) {
-		return &ExampleService{}, nil
+	// services created by the registry are run after their dependencies
+	s := service.MustCreate(r.Register(func(cmd *cobra.Command, base *service.Base) (*ExampleService, error) {
+		return &ExampleService{Base: base}, nil
 	})).(*ExampleService)
 
-	r.Create(&s.Base)
 	s.CreateDebugServer(r, 4008)
-	r.Create(&s)
 }
 
 func main() {
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Label is a label of a metric
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Labels returns the labels of a map sorted by name
+func Labels(labels map[string]string) []Label {
+	result := make([]Label, 0, len(labels))
+	for name, value := range labels {
+		result = append(result, Label{Name: name, Value: value})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// MetricName returns the name a metric is registered with in a metrics.Registry, e.g.
+//
+//	metrics.GetOrRegisterCounter(MetricName("app", "requests", Label{"partner", `"Shopee", Inc.`}), r)
+//
+// Unlike writing "app,partner=... requests" by hand, label values may contain any character. They
+// are percent-encoded in the name and escaped when exported by PrometheusMetrics. Label names are
+// not encoded, MetricName panics if a name is empty or contains a comma, an equals sign or a space.
+func MetricName(prefix, name string, labels ...Label) string {
+	var b strings.Builder
+	b.WriteString(prefix)
+	for _, l := range labels {
+		if l.Name == "" || strings.ContainsAny(l.Name, ", =") {
+			panic(fmt.Sprintf("invalid label name %q for metric %s", l.Name, name))
+		}
+		fmt.Fprintf(&b, ",%s=%s", l.Name, encodeLabelValue(l.Value))
+	}
+	b.WriteString(" ")
+	b.WriteString(name)
+	return b.String()
+}
+
+// MetricNameWithLabels is MetricName with labels sorted by name
+func MetricNameWithLabels(prefix, name string, labels map[string]string) string {
+	return MetricName(prefix, name, Labels(labels)...)
+}
+
+// isLabelValueChar returns true for characters allowed in label values of metric names as is
+func isLabelValueChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		strings.IndexByte("_:-+./", c) >= 0
+}
+
+func encodeLabelValue(v string) string {
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		if c := v[i]; isLabelValueChar(c) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// decodeLabelValue decodes a label value encoded by MetricName
+func decodeLabelValue(v string) (string, error) {
+	if !strings.Contains(v, "%") {
+		return v, nil
+	}
+	decoded, err := url.PathUnescape(v)
+	if err != nil {
+		return "", err
+	}
+	if !utf8.ValidString(decoded) {
+		return "", fmt.Errorf("invalid UTF-8")
+	}
+	return decoded, nil
+}
+
+// escapeLabelValue escapes a label value for the Prometheus text and OpenMetrics formats
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
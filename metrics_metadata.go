@@ -0,0 +1,87 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// MetricMetadata describes a metric family. Unit is the base unit of the values, e.g. "seconds" or
+// "bytes". It is only exported in the OpenMetrics format if the family name ends with it.
+type MetricMetadata struct {
+	Help string
+	Unit string
+}
+
+// MetricsMetadata is a side registry holding the metadata of metrics registered with a
+// metrics.Registry. Metrics are identified by their signature without labels, so the metadata
+// applies to all metrics of a family.
+type MetricsMetadata struct {
+	mu       sync.RWMutex
+	families map[string]MetricMetadata
+}
+
+// DefaultMetricsMetadata is used by PrometheusMetrics unless configured otherwise
+var DefaultMetricsMetadata = NewMetricsMetadata()
+
+// derivedFamilySuffixes are the suffixes of families PrometheusMetrics derives from a histogram or timer
+var derivedFamilySuffixes = []string{"_buckets", "_min", "_max", "_mean", "_stddev"}
+
+func NewMetricsMetadata() *MetricsMetadata {
+	return &MetricsMetadata{families: map[string]MetricMetadata{}}
+}
+
+// Describe sets the help text and unit of all metrics with the same signature ignoring labels, e.g.
+// "app,handler=click requests" describes "app,handler=view requests" as well. It returns the
+// signature so it can be used when registering a metric:
+//
+//	metrics.GetOrRegisterCounter(m.Describe("app,handler=click requests", "Number of requests", ""), r)
+func (m *MetricsMetadata) Describe(signature, help, unit string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.families[familyName(signature)] = MetricMetadata{Help: help, Unit: unit}
+	return signature
+}
+
+// Lookup returns the metadata of a metric family by its Prometheus name. Families derived from a
+// histogram or timer, like the "_max" gauge, use the metadata of their origin.
+func (m *MetricsMetadata) Lookup(family string) (MetricMetadata, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if md, ok := m.families[family]; ok {
+		return md, true
+	}
+	for _, suffix := range derivedFamilySuffixes {
+		if md, ok := m.families[strings.TrimSuffix(family, suffix)]; ok && strings.HasSuffix(family, suffix) {
+			md.Help = fmt.Sprintf("%s (%s)", md.Help, strings.TrimPrefix(suffix, "_"))
+			return md, true
+		}
+	}
+	return MetricMetadata{}, false
+}
+
+// DescribeMetric describes a metric using DefaultMetricsMetadata, see MetricsMetadata.Describe
+func DescribeMetric(signature, help, unit string) string {
+	return DefaultMetricsMetadata.Describe(signature, help, unit)
+}
+
+// familyName returns the Prometheus name of the family of a metric signature like
+// "prefix,label=value name"
+func familyName(signature string) string {
+	split := strings.SplitN(signature, " ", 2)
+	if len(split) != 2 {
+		return prometheusMetricName(signature)
+	}
+	prefix := strings.SplitN(split[0], ",", 2)[0]
+	return prometheusMetricName(prefix + "_" + split[1])
+}
+
+// escapeHelp escapes a help text for the Prometheus text format. The OpenMetrics format escapes
+// double quotes as well.
+func escapeHelp(help string, openMetrics bool) string {
+	r := strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	if openMetrics {
+		r = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	}
+	return r.Replace(help)
+}
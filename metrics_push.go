@@ -0,0 +1,162 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/remerge/cue"
+)
+
+// metricsPusher pushes the metrics to a Prometheus Pushgateway. Batch jobs usually end before the
+// debug server is scraped, so their metrics would be lost otherwise. It is a Service added to the
+// runner before Base, so the last push happens after the services added after Base are shutdown.
+type metricsPusher struct {
+	url      string
+	job      string
+	interval time.Duration
+	retries  int
+	// backoff is the wait before the first retry, it doubles with every retry
+	backoff time.Duration
+
+	log         cue.Logger
+	promMetrics *PrometheusMetrics
+	client      *http.Client
+
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newMetricsPusher(log cue.Logger, promMetrics *PrometheusMetrics, job string) *metricsPusher {
+	return &metricsPusher{
+		job:         job,
+		interval:    10 * time.Second,
+		retries:     3,
+		backoff:     500 * time.Millisecond,
+		log:         log,
+		promMetrics: promMetrics,
+		client:      &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (p *metricsPusher) enabled() bool {
+	return p.url != ""
+}
+
+// Init starts the periodic pushes if a pushgateway is configured
+func (p *metricsPusher) Init() error {
+	if p.enabled() {
+		p.start()
+	}
+	return nil
+}
+
+// Shutdown pushes the metrics once more, otherwise everything since the last push is lost
+func (p *metricsPusher) Shutdown(os.Signal) {
+	if !p.enabled() {
+		return
+	}
+	if err := p.flush(); err != nil {
+		_ = p.log.Errorf(err, "Error pushing metrics")
+	}
+}
+
+// start pushes the metrics every interval until flush is called
+func (p *metricsPusher) start() {
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+	go p.run()
+}
+
+func (p *metricsPusher) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			// select picks a random case if the ticker fired while stopping
+			select {
+			case <-p.stop:
+				return
+			default:
+			}
+			// nothing was collected yet, pushing would delete the metrics of the job
+			if p.promMetrics.String() == "" {
+				continue
+			}
+			if err := p.pushWithRetries(p.stop); err != nil {
+				p.log.Warnf("failed to push metrics: %v", err)
+			}
+		}
+	}
+}
+
+// flush stops the periodic pushes, collects the metrics and pushes them. It waits for the periodic pushes to
+// stop so the last push can't be replaced by an older one.
+func (p *metricsPusher) flush() error {
+	if p.stop != nil {
+		close(p.stop)
+		<-p.done
+	}
+	if err := p.promMetrics.Update(); err != nil {
+		p.log.Warnf("failures while collect metrics: %v", err)
+	}
+	return p.pushWithRetries(nil)
+}
+
+// pushWithRetries pushes the metrics and retries failed pushes with a doubling backoff. Retrying is given
+// up once abort is closed.
+func (p *metricsPusher) pushWithRetries(abort <-chan struct{}) error {
+	backoff := p.backoff
+	err := p.push()
+	for retry := 1; err != nil && retry <= p.retries; retry++ {
+		p.log.WithValue("retry", retry).Warnf("failed to push metrics, retrying in %v: %v", backoff, err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-abort:
+			timer.Stop()
+			return err
+		}
+		backoff *= 2
+		err = p.push()
+	}
+	return err
+}
+
+// push replaces the metrics of the job in the Pushgateway with the metrics collected last
+func (p *metricsPusher) push() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	u := strings.TrimSuffix(p.url, "/") + "/metrics/job/" + url.PathEscape(p.job)
+	req, err := http.NewRequest(http.MethodPut, u, strings.NewReader(p.promMetrics.String()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ContentTypePrometheusText)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("pushgateway responded with %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type pushgateway struct {
+	mu       sync.Mutex
+	failures int
+	pushes   []string
+	// attempted and pushed receive a value for every push and every successful push if set
+	attempted chan struct{}
+	pushed    chan struct{}
+}
+
+func (g *pushgateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if r.Method != http.MethodPut || r.URL.Path != "/metrics/job/test job" {
+		http.Error(w, "unexpected request "+r.Method+" "+r.URL.Path, http.StatusBadRequest)
+		return
+	}
+	notify(g.attempted)
+	if g.failures > 0 {
+		g.failures--
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	g.pushes = append(g.pushes, string(body))
+	notify(g.pushed)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func notify(c chan struct{}) {
+	if c == nil {
+		return
+	}
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// awaitPush waits for the next successful push
+func (g *pushgateway) awaitPush(t *testing.T) {
+	select {
+	case <-g.pushed:
+	case <-time.After(time.Second):
+		t.Fatal("no metrics were pushed in time")
+	}
+}
+
+func (g *pushgateway) received() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string(nil), g.pushes...)
+}
+
+func TestMetricsPusher(t *testing.T) {
+	newPusher := func(gateway *pushgateway) (*metricsPusher, metrics.Counter, func()) {
+		server := httptest.NewServer(gateway)
+		r := metrics.NewRegistry()
+		p := newMetricsPusher(NewLogger("test"), NewPrometheusMetrics(r, "test"), "test job")
+		p.url = server.URL + "/"
+		p.interval = 10 * time.Millisecond
+		p.backoff = time.Millisecond
+		return p, metrics.GetOrRegisterCounter("app requests", r), server.Close
+	}
+
+	t.Run("pushes periodically and once more on flush", func(t *testing.T) {
+		gateway := &pushgateway{pushed: make(chan struct{}, 1)}
+		p, counter, stop := newPusher(gateway)
+		defer stop()
+		require.NoError(t, p.promMetrics.Update())
+
+		require.NoError(t, p.Init())
+		gateway.awaitPush(t)
+		assert.Contains(t, gateway.received()[0], `app_requests_total{service="test"} 0`)
+
+		counter.Inc(3)
+		require.NoError(t, p.flush())
+		pushes := gateway.received()
+		assert.Contains(t, pushes[len(pushes)-1], `app_requests_total{service="test"} 3`)
+	})
+
+	t.Run("flush aborts the retries of a periodic push", func(t *testing.T) {
+		gateway := &pushgateway{failures: 1, attempted: make(chan struct{}, 1)}
+		p, _, stop := newPusher(gateway)
+		defer stop()
+		require.NoError(t, p.promMetrics.Update())
+		p.backoff = time.Hour
+
+		require.NoError(t, p.Init())
+		select {
+		case <-gateway.attempted:
+		case <-time.After(time.Second):
+			t.Fatal("no push was attempted in time")
+		}
+		// the periodic push is waiting for its retry, the final push goes through
+		require.NoError(t, p.flush())
+		assert.Len(t, gateway.received(), 1)
+	})
+
+	t.Run("retries the final push", func(t *testing.T) {
+		gateway := &pushgateway{failures: 3}
+		p, _, stop := newPusher(gateway)
+		defer stop()
+
+		require.NoError(t, p.flush())
+		assert.Len(t, gateway.received(), 1)
+	})
+
+	t.Run("gives up after all retries", func(t *testing.T) {
+		gateway := &pushgateway{failures: 4}
+		p, _, stop := newPusher(gateway)
+		defer stop()
+
+		err := p.flush()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "503 Service Unavailable: unavailable")
+		assert.Empty(t, gateway.received())
+	})
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"fmt"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Content types of the supported metrics exposition formats
+const (
+	ContentTypePrometheusText = "text/plain; version=0.0.4; charset=utf-8"
+	ContentTypeOpenMetrics    = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// OpenMetricsString returns the metrics in the OpenMetrics 1.0 format. Unlike the text format it
+// has no blank lines and no errors, names counter families without the "_total" suffix, adds
+// "_created" samples to counters, histograms and summaries and ends with "# EOF". Units are only
+// exported for families whose name ends with the unit.
+func (p *PrometheusMetrics) OpenMetricsString() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.openMetricsCache.String()
+}
+
+// Negotiate returns the content type and the metrics in the format preferred by the given Accept
+// header. It prefers the Prometheus text format if both formats are equally acceptable.
+func (p *PrometheusMetrics) Negotiate(accept string) (contentType, body string) {
+	if prefersOpenMetrics(accept) {
+		return ContentTypeOpenMetrics, p.OpenMetricsString()
+	}
+	return ContentTypePrometheusText, p.String()
+}
+
+// prefersOpenMetrics returns true if the Accept header rates OpenMetrics higher than the text format
+func prefersOpenMetrics(accept string) bool {
+	var openMetrics, text float64
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case "application/openmetrics-text":
+			if q > openMetrics {
+				openMetrics = q
+			}
+		case "text/plain", "text/*", "*/*":
+			if q > text {
+				text = q
+			}
+		}
+	}
+	return openMetrics > text
+}
+
+func (p *PrometheusMetrics) writeOpenMetrics(families promFamilies) (err error) {
+	p.openMetricsCache.Reset()
+
+	var names []string
+	for name := range families {
+		// invalid metrics are reported in the text format only
+		if promMetricRe.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	now := time.Now()
+	for _, name := range names {
+		f := families[name]
+		md, described := p.metadata(name)
+		if described && md.Help != "" {
+			if _, err = fmt.Fprintf(&p.openMetricsCache, "# HELP %s %s\n", name, escapeHelp(md.Help, true)); err != nil {
+				return err
+			}
+		}
+		if _, err = fmt.Fprintf(&p.openMetricsCache, "# TYPE %s %s\n", name, f.typ); err != nil {
+			return err
+		}
+		// the unit must be a suffix of the family name
+		if described && md.Unit != "" && strings.HasSuffix(name, "_"+md.Unit) {
+			if _, err = fmt.Fprintf(&p.openMetricsCache, "# UNIT %s %s\n", name, md.Unit); err != nil {
+				return err
+			}
+		}
+		for _, metric := range f.metrics() {
+			for _, s := range metric {
+				suffix, value := s.suffix, s.value
+				if f.legacyBuckets && suffix == "" {
+					suffix = "_bucket"
+					if s.bound == `,le="+Inf"` {
+						// the +Inf bucket counts all values
+						value = metricCount(metric)
+					}
+				}
+				if _, err = fmt.Fprintf(&p.openMetricsCache, "%s %s\n", p.fullName(name+suffix, s.labels+s.bound), value); err != nil {
+					return err
+				}
+			}
+			switch f.typ {
+			case "counter", "histogram", "summary":
+				created := p.createdAt(name+metric[0].labels, now)
+				if _, err = fmt.Fprintf(&p.openMetricsCache, "%s %s\n", p.fullName(name+"_created", metric[0].labels), created); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	_, err = fmt.Fprint(&p.openMetricsCache, "# EOF\n")
+	return err
+}
+
+// metrics returns the samples of the family grouped by their labels. The groups are sorted by
+// labels, the samples of a group keep the order they were added in.
+func (f *promFamily) metrics() [][]promSample {
+	samples := append([]promSample(nil), f.samples...)
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].labels < samples[j].labels
+	})
+	var metrics [][]promSample
+	for i, s := range samples {
+		if i == 0 || s.labels != samples[i-1].labels {
+			metrics = append(metrics, nil)
+		}
+		metrics[len(metrics)-1] = append(metrics[len(metrics)-1], s)
+	}
+	return metrics
+}
+
+func metricCount(samples []promSample) string {
+	for _, s := range samples {
+		if s.suffix == "_count" {
+			return s.value
+		}
+	}
+	return "0"
+}
+
+// createdAt returns the time the metric was seen first in seconds since the epoch
+func (p *PrometheusMetrics) createdAt(key string, now time.Time) string {
+	created, ok := p.created[key]
+	if !ok {
+		created = now
+		p.created[key] = created
+	}
+	return strconv.FormatFloat(float64(created.UnixNano())/1e9, 'f', 3, 64)
+}
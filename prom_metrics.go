@@ -5,18 +5,25 @@ import (
 	"fmt"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/rcrowley/go-metrics"
+	lft "github.com/remerge/go-lock_free_timer"
 	lft_sample "github.com/remerge/go-lock_free_timer/sample"
 )
 
 var (
 	promMetricRe      = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
 	promMetricLabelRe = regexp.MustCompile(`^[a-zA-Z0-9_]*$`)
-	promMetricValueRe = regexp.MustCompile(`^[a-zA-Z0-9_:\-\+\.\/]*$`)
+	// promMetricValueRe matches label values, which may be percent-encoded, see MetricName
+	promMetricValueRe = regexp.MustCompile(`^[a-zA-Z0-9_:\-\+\.\/%]*$`)
+	// promBucketLabelRe matches the le label of native histogram buckets, see seriesSortKey
+	promBucketLabelRe = regexp.MustCompile(`,le="[^"]*"`)
 )
 
 type metricsSampler interface {
@@ -30,29 +37,88 @@ type metricsSampler interface {
 }
 
 // PrometheusMetrics converts all metrics from bounded registry to
-// prometheus text format and stores them in internal cache.
-// See https://prometheus.io/docs/instrumenting/exposition_formats
+// prometheus text format and the OpenMetrics format and stores them in internal caches.
+// See https://prometheus.io/docs/instrumenting/exposition_formats and
+// https://github.com/OpenObservability/OpenMetrics/blob/main/specification/OpenMetrics.md
 type PrometheusMetrics struct {
+	// NativeHistograms makes all histograms with a bucket sample export native Prometheus
+	// histograms, see Update. Histograms using a sample created by NewHistogramSample always
+	// export native histograms.
+	NativeHistograms bool
+	// Metadata holds the help texts and units exported with the metrics, it defaults to
+	// DefaultMetricsMetadata
+	Metadata *MetricsMetadata
+
 	registry  metrics.Registry
 	nameLabel string
 
-	mu    sync.RWMutex
-	cache bytes.Buffer
+	mu               sync.RWMutex
+	cache            bytes.Buffer
+	openMetricsCache bytes.Buffer
+	// created holds the time every counter, histogram and summary was seen first
+	created map[string]time.Time
+	// histogramTotals are the count and sum of native histograms not using a histogramSample
+	histogramTotals map[string]*histogramTotals
+}
+
+type histogramTotals struct {
+	count int64
+	sum   int64
 }
 
 func NewPrometheusMetrics(registry metrics.Registry, name string) (p *PrometheusMetrics) {
 	return &PrometheusMetrics{
-		registry:  registry,
-		nameLabel: fmt.Sprintf("service=\"%s\"", name),
+		Metadata:        DefaultMetricsMetadata,
+		registry:        registry,
+		nameLabel:       fmt.Sprintf("service=\"%s\"", name),
+		histogramTotals: map[string]*histogramTotals{},
+		created:         map[string]time.Time{},
 	}
 }
 
+// String returns the metrics in the Prometheus text format 0.0.4
 func (p *PrometheusMetrics) String() string {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 	return p.cache.String()
 }
 
+// promFamilies are the metric families collected by Update by name
+type promFamilies map[string]*promFamily
+
+// promFamily is a metric family collected by Update
+type promFamily struct {
+	typ string
+	// legacyBuckets is set for histograms with the "_buckets" suffix, see addBucketHistogramSummary
+	legacyBuckets bool
+	samples       []promSample
+}
+
+// promSample is a single sample of a metric family
+type promSample struct {
+	// suffix is appended to the family name, e.g. "_count"
+	suffix string
+	// labels identify the metric within its family, e.g. `,l1="1"`
+	labels string
+	// bound is the le label of histogram buckets or the quantile label of summaries
+	bound string
+	value string
+}
+
+func (f promFamilies) family(name, typ string) *promFamily {
+	family, ok := f[name]
+	if !ok {
+		family = &promFamily{}
+		f[name] = family
+	}
+	family.typ = typ
+	return family
+}
+
+func (f *promFamily) add(suffix, labels, bound string, value interface{}) {
+	f.samples = append(f.samples, promSample{suffix: suffix, labels: labels, bound: bound, value: fmt.Sprint(value)})
+}
+
 /*
 Update updates internal cache with metrics collected from bounded registry.
 All entities are sorted. Update() is thread-safe.
@@ -65,6 +131,12 @@ All failures during collecting are also stored in beginning:
 Counters are represented as "XXX_counter" as well as "XXX_total" for
 compatibility reasons:
 
+Metrics described by Metadata are preceded by their help text and unit:
+
+	# HELP app_t1 Time spent handling a request
+	# TYPE app_t1 summary
+	# UNIT app_t1 seconds
+
 	# TYPE app_c1_count counter
 	app_c1_count{service="test",l1="2"} 0
 	app_c1_count{service="test",label1="1",label2="2"} 2
@@ -79,7 +151,9 @@ Both int and float Gauges are represented as gauges:
 	app_g1{service="test",l1="1"} 0
 	app_g1{service="test",l1="2"} 0
 
-Timers and Histograms are represented as Prometheus summaries (see below):
+Timers and Histograms are represented as Prometheus summaries (see below).
+Histograms with a bucket sample (see lft.NewLockFreeSampleWithBuckets) are
+additionally represented as histograms with a "_buckets" suffix:
 
 	# TYPE app_h1 summary
 	app_h1_count{service="test",l1="1"} 0
@@ -114,6 +188,17 @@ Timers and Histograms are represented as Prometheus summaries (see below):
 	# TYPE app_h1_stddev gauge
 	app_h1_stddev{service="test",l1="1"} 0
 
+Histograms with a bucket sample are represented as native Prometheus histograms
+instead if NativeHistograms is set or if the sample was created by NewHistogramSample.
+Their buckets are cumulative and can be aggregated using histogram_quantile:
+
+	# TYPE app_h2 histogram
+	app_h2_bucket{service="test",l1="1",le="10"} 1
+	app_h2_bucket{service="test",l1="1",le="20"} 2
+	app_h2_bucket{service="test",l1="1",le="+Inf"} 4
+	app_h2_count{service="test",l1="1"} 4
+	app_h2_sum{service="test",l1="1"} 76
+
 Meters are represented as counters (see above):
 
 	# TYPE app_m1_count counter
@@ -131,8 +216,7 @@ func (p *PrometheusMetrics) Update() error {
 	// we need to restructure go-metrics registry
 
 	var failures []string
-	mTypes := map[string]string{}
-	mValues := map[string][][2]string{}
+	families := promFamilies{}
 
 	p.registry.Each(func(s string, i interface{}) {
 		var name, labels string
@@ -142,49 +226,56 @@ func (p *PrometheusMetrics) Update() error {
 		}
 		switch m1 := i.(type) {
 		case metrics.Counter:
-			p.addCounter(mTypes, mValues, name, labels, m1.Count())
+			p.addCounter(families, name, labels, m1.Count())
 		case metrics.Meter:
-			p.addCounter(mTypes, mValues, name, labels, m1.Count())
+			p.addCounter(families, name, labels, m1.Count())
 		case metrics.Gauge:
-			p.addGauge(mTypes, mValues, name, labels, fmt.Sprint(m1.Value()))
+			p.addGauge(families, name, labels, fmt.Sprint(m1.Value()))
 		case metrics.GaugeFloat64:
-			p.addGauge(mTypes, mValues, name, labels, fmt.Sprint(m1.Value()))
+			p.addGauge(families, name, labels, fmt.Sprint(m1.Value()))
 		case metrics.Healthcheck:
 			// also gauge
 			val := "1"
 			if m1.Error() != nil {
 				val = "0"
 			}
-			p.addGauge(mTypes, mValues, name, labels, val)
+			p.addGauge(families, name, labels, val)
 		case metrics.Histogram:
-			p.updateHistogram(mTypes, mValues, name, labels, m1)
+			p.updateHistogram(families, name, labels, m1)
 		case metrics.Timer:
 			sn := m1.Snapshot()
 			if sn.Count() == 0 {
 				break
 			}
 
-			p.addSummary(mTypes, mValues, name, labels, sn)
+			p.addSummary(families, name, labels, sn)
 		}
 	})
-	return p.writeData(failures, mTypes, mValues)
+	if err := p.writeOpenMetrics(families); err != nil {
+		return err
+	}
+	return p.writeData(failures, families)
 }
 
-func (p *PrometheusMetrics) updateHistogram(mTypes map[string]string, mValues map[string][][2]string, name, labels string, hst metrics.Histogram) {
+func (p *PrometheusMetrics) updateHistogram(families promFamilies, name, labels string, hst metrics.Histogram) {
 	withBuckets, ok := hst.Sample().(lft_sample.SampleWithBuckets)
+	if hs, native := withBuckets.(*histogramSample); native || (ok && p.NativeHistograms) {
+		p.addHistogram(families, name, labels, withBuckets, hs)
+		return
+	}
 	if ok {
 		// Amount of events is not checked here intentionally: a histogram output
 		// with zero values is considered valid
-		p.addBucketHistogramSummary(mTypes, mValues, name, labels, withBuckets)
+		p.addBucketHistogramSummary(families, name, labels, withBuckets)
 	}
 
 	sn := hst.Snapshot()
 	if sn.Count() > 0 {
-		p.addSummary(mTypes, mValues, name, labels, sn)
+		p.addSummary(families, name, labels, sn)
 	}
 }
 
-func (p *PrometheusMetrics) writeData(failures []string, t map[string]string, v map[string][][2]string) (err error) {
+func (p *PrometheusMetrics) writeData(failures []string, families promFamilies) (err error) {
 	p.cache.Reset()
 
 	// write failures
@@ -197,20 +288,48 @@ func (p *PrometheusMetrics) writeData(failures []string, t map[string]string, v
 		}
 	}
 
+	// counters are named after their samples in the text format
+	textNames := map[string]string{}
 	var mNames []string
-	for name := range t {
-		mNames = append(mNames, name)
+	for name, f := range families {
+		textName := name
+		if f.typ == "counter" {
+			textName += "_total"
+		}
+		textNames[textName] = name
+		mNames = append(mNames, textName)
 	}
 	sort.Strings(mNames)
 
-	for _, name := range mNames {
-		if _, err = fmt.Fprintf(&p.cache, "\n# TYPE %s %s\n", name, t[name]); err != nil {
+	for _, textName := range mNames {
+		name := textNames[textName]
+		f := families[name]
+		md, described := p.metadata(name)
+		if _, err = fmt.Fprint(&p.cache, "\n"); err != nil {
 			return err
 		}
-		sort.Slice(v[name], func(i, j int) bool {
-			return v[name][i][0] < v[name][j][0]
+		if described && md.Help != "" {
+			if _, err = fmt.Fprintf(&p.cache, "# HELP %s %s\n", textName, escapeHelp(md.Help, false)); err != nil {
+				return err
+			}
+		}
+		if _, err = fmt.Fprintf(&p.cache, "# TYPE %s %s\n", textName, f.typ); err != nil {
+			return err
+		}
+		// the text format has no units, parsers ignore the line as a comment
+		if described && md.Unit != "" {
+			if _, err = fmt.Fprintf(&p.cache, "# UNIT %s %s\n", textName, md.Unit); err != nil {
+				return err
+			}
+		}
+		series := make([][2]string, len(f.samples))
+		for i, s := range f.samples {
+			series[i] = [2]string{p.fullName(name+s.suffix, s.labels+s.bound), s.value}
+		}
+		sort.SliceStable(series, func(i, j int) bool {
+			return seriesSortKey(series[i][0]) < seriesSortKey(series[j][0])
 		})
-		for _, value := range v[name] {
+		for _, value := range series {
 			if _, err = fmt.Fprintf(&p.cache, "%s %s\n", value[0], value[1]); err != nil {
 				return err
 			}
@@ -223,50 +342,88 @@ func (p *PrometheusMetrics) writeData(failures []string, t map[string]string, v
 	return nil
 }
 
-func (p *PrometheusMetrics) addBucketHistogramSummary(t map[string]string, v map[string][][2]string, name, labels string, sampler lft_sample.SampleWithBuckets) {
+func (p *PrometheusMetrics) metadata(name string) (MetricMetadata, bool) {
+	if p.Metadata == nil {
+		return MetricMetadata{}, false
+	}
+	return p.Metadata.Lookup(name)
+}
+
+func (p *PrometheusMetrics) addBucketHistogramSummary(families promFamilies, name, labels string, sampler lft_sample.SampleWithBuckets) {
 	name = name + "_buckets"
-	t[name] = "histogram"
+	f := families.family(name, "histogram")
+	f.legacyBuckets = true
 
 	buckets, values := sampler.BucketsAndValues()
 	for idx := 0; idx < len(buckets); idx++ {
-		p.addV(v, name, p.fullName(name, fmt.Sprintf("%s,le=\"%f\"", labels, buckets[idx])), values[idx])
+		f.add("", labels, fmt.Sprintf(",le=\"%f\"", buckets[idx]), values[idx])
 	}
-	p.addV(v, name, p.fullName(name, labels+",le=\"+Inf\""), values[len(buckets)])
+	f.add("", labels, ",le=\"+Inf\"", values[len(buckets)])
 
-	p.addV(v, name, p.fullName(name+"_count", labels), sampler.Count())
-	p.addV(v, name, p.fullName(name+"_sum", labels), sampler.Sum())
+	f.add("_count", labels, "", sampler.Count())
+	f.add("_sum", labels, "", sampler.Sum())
 }
 
-func (p *PrometheusMetrics) addSummary(t map[string]string, v map[string][][2]string, name, labels string, sampler metricsSampler) {
-	t[name] = "summary"
-	p.addV(v, name, p.fullName(name+"_count", labels), sampler.Count())
-	p.addV(v, name, p.fullName(name+"_sum", labels), sampler.Sum())
+// addHistogram adds a native histogram. The sum and count of samples created by NewHistogramSample
+// are exact. Other samples are reset by taking a snapshot, so their count and sum are accumulated
+// from the snapshots of every Update. The sum is estimated if more values than the reservoir size
+// were added between two updates.
+func (p *PrometheusMetrics) addHistogram(families promFamilies, name, labels string, sampler lft_sample.SampleWithBuckets, hs *histogramSample) {
+	f := families.family(name, "histogram")
 
-	ps := sampler.Percentiles([]float64{0.5, 0.75, 0.95, 0.99, 0.999})
-	p.addV(v, name, p.fullName(name, labels+",quantile=\"0.5\""), ps[0])
-	p.addV(v, name, p.fullName(name, labels+",quantile=\"0.75\""), ps[1])
-	p.addV(v, name, p.fullName(name, labels+",quantile=\"0.95\""), ps[2])
-	p.addV(v, name, p.fullName(name, labels+",quantile=\"0.99\""), ps[3])
-	p.addV(v, name, p.fullName(name, labels+",quantile=\"0.999\""), ps[4])
-
-	p.addGauge(t, v, name+"_min", labels, sampler.Min())
-	p.addGauge(t, v, name+"_max", labels, sampler.Max())
-	p.addGauge(t, v, name+"_mean", labels, sampler.Mean())
-	p.addGauge(t, v, name+"_stddev", labels, sampler.StdDev())
+	buckets, values := sampler.BucketsAndValues()
+	var count, sum int64
+	if hs != nil {
+		count, sum = hs.CountAndSum()
+	} else {
+		key := name + labels
+		totals, ok := p.histogramTotals[key]
+		if !ok {
+			totals = &histogramTotals{}
+			p.histogramTotals[key] = totals
+		}
+		sn := sampler.Snapshot()
+		totals.count += sn.Count()
+		totals.sum += snapshotSum(sn)
+		count, sum = totals.count, totals.sum
+	}
+	// the bucket values are cumulative but never reset, unlike the reservoir
+	if last := values[len(buckets)-1]; count < last {
+		count = last
+	}
+
+	for idx := 0; idx < len(buckets); idx++ {
+		f.add("_bucket", labels, fmt.Sprintf(",le=\"%s\"", formatBucket(buckets[idx])), values[idx])
+	}
+	f.add("_bucket", labels, ",le=\"+Inf\"", count)
+	f.add("_count", labels, "", count)
+	f.add("_sum", labels, "", sum)
 }
 
-func (p *PrometheusMetrics) addCounter(t map[string]string, v map[string][][2]string, name, labels string, value int64) {
-	t[name+"_total"] = "counter"
-	p.addV(v, name+"_total", p.fullName(name+"_total", labels), value)
+func (p *PrometheusMetrics) addSummary(families promFamilies, name, labels string, sampler metricsSampler) {
+	f := families.family(name, "summary")
+	f.add("_count", labels, "", sampler.Count())
+	f.add("_sum", labels, "", sampler.Sum())
+
+	ps := sampler.Percentiles([]float64{0.5, 0.75, 0.95, 0.99, 0.999})
+	f.add("", labels, ",quantile=\"0.5\"", ps[0])
+	f.add("", labels, ",quantile=\"0.75\"", ps[1])
+	f.add("", labels, ",quantile=\"0.95\"", ps[2])
+	f.add("", labels, ",quantile=\"0.99\"", ps[3])
+	f.add("", labels, ",quantile=\"0.999\"", ps[4])
+
+	p.addGauge(families, name+"_min", labels, sampler.Min())
+	p.addGauge(families, name+"_max", labels, sampler.Max())
+	p.addGauge(families, name+"_mean", labels, sampler.Mean())
+	p.addGauge(families, name+"_stddev", labels, sampler.StdDev())
 }
 
-func (p *PrometheusMetrics) addGauge(t map[string]string, v map[string][][2]string, bind, labels string, value interface{}) {
-	t[bind] = "gauge"
-	p.addV(v, bind, p.fullName(bind, labels), value)
+func (p *PrometheusMetrics) addCounter(families promFamilies, name, labels string, value int64) {
+	families.family(name, "counter").add("_total", labels, "", value)
 }
 
-func (p *PrometheusMetrics) addV(v map[string][][2]string, bind, fullname string, value interface{}) {
-	v[bind] = append(v[bind], [2]string{fullname, fmt.Sprint(value)})
+func (p *PrometheusMetrics) addGauge(families promFamilies, name, labels string, value interface{}) {
+	families.family(name, "gauge").add("", labels, "", value)
 }
 
 func (p *PrometheusMetrics) extractSignature(raw string) (name, labels string, err error) {
@@ -296,7 +453,13 @@ func (p *PrometheusMetrics) extractSignature(raw string) (name, labels string, e
 			multiErr = multierror.Append(multiErr, err)
 			continue
 		}
-		labels += fmt.Sprintf(`,%s="%s"`, prometheusMetricName(lSplit[0]), lSplit[1])
+		value, decodeErr := decodeLabelValue(lSplit[1])
+		if decodeErr != nil {
+			err = fmt.Errorf(`bad label value "%s" in metric "%s": %v`, l, raw, decodeErr)
+			multiErr = multierror.Append(multiErr, err)
+			continue
+		}
+		labels += fmt.Sprintf(`,%s="%s"`, prometheusMetricName(lSplit[0]), escapeLabelValue(value))
 	}
 	return name, labels, multiErr
 }
@@ -305,6 +468,55 @@ func (p *PrometheusMetrics) fullName(name, labels string) (f string) {
 	return fmt.Sprintf("%s{%s%s}", name, p.nameLabel, labels)
 }
 
+// seriesSortKey returns the key series of a metric are sorted by. Native histogram buckets of a
+// series share a key, so they keep their order of ascending bounds.
+func seriesSortKey(series string) string {
+	if !strings.Contains(series, "_bucket{") {
+		return series
+	}
+	return promBucketLabelRe.ReplaceAllString(series, "")
+}
+
+func formatBucket(b float64) string {
+	return strconv.FormatFloat(b, 'g', -1, 64)
+}
+
+// snapshotSum returns the sum of all values of a snapshot. If there were more values than the
+// reservoir size the sum is estimated from the mean.
+func snapshotSum(sn metrics.Sample) int64 {
+	if sn.Count() <= int64(len(sn.Values())) {
+		return sn.Sum()
+	}
+	return int64(sn.Mean() * float64(sn.Count()))
+}
+
 func prometheusMetricName(in string) (out string) {
 	return strings.Replace(in, "-", "_", -1)
 }
+
+// histogramSample is a sample with buckets that tracks the exact count and sum of all values
+type histogramSample struct {
+	lft_sample.SampleWithBuckets
+	count int64
+	sum   int64
+}
+
+// NewHistogramSample creates a lock free sample with the given bucket upper bounds. Histograms using
+// it are exported as native Prometheus histograms by PrometheusMetrics, regardless of
+// PrometheusMetrics.NativeHistograms. Like the buckets its count and sum are never reset.
+func NewHistogramSample(buckets ...float64) metrics.Sample {
+	return &histogramSample{
+		SampleWithBuckets: lft.NewLockFreeSampleWithBuckets(buckets).(lft_sample.SampleWithBuckets),
+	}
+}
+
+func (s *histogramSample) Update(v int64) {
+	s.SampleWithBuckets.Update(v)
+	atomic.AddInt64(&s.count, 1)
+	atomic.AddInt64(&s.sum, v)
+}
+
+// CountAndSum returns the number of all values and their sum
+func (s *histogramSample) CountAndSum() (count, sum int64) {
+	return atomic.LoadInt64(&s.count), atomic.LoadInt64(&s.sum)
+}
@@ -1,6 +1,8 @@
 package service_test
 
 import (
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 
@@ -122,6 +124,240 @@ app_t1_stddev{service="test"} 0
 `, p.String())
 }
 
+func TestPrometheusMetrics_NativeHistograms(t *testing.T) {
+	newRegistry := func() metrics.Registry {
+		r := metrics.NewRegistry()
+		h1 := metrics.GetOrRegisterHistogram("app,l1=1 h1", r, lft.NewLockFreeSampleWithBuckets([]float64{10, 20, 100}))
+		h2 := metrics.GetOrRegisterHistogram("app,l1=1 h2", r, service.NewHistogramSample(0.5, 10, 20, 100))
+		for _, v := range []int64{5, 15, 25, 31, 150} {
+			h1.Update(v)
+			h2.Update(v)
+		}
+		return r
+	}
+
+	t.Run("per metric", func(t *testing.T) {
+		p := service.NewPrometheusMetrics(newRegistry(), "test")
+		require.NoError(t, p.Update())
+		require.Contains(t, p.String(), "# TYPE app_h1_buckets histogram")
+		require.Contains(t, p.String(), `
+# TYPE app_h2 histogram
+app_h2_bucket{service="test",l1="1",le="0.5"} 0
+app_h2_bucket{service="test",l1="1",le="10"} 1
+app_h2_bucket{service="test",l1="1",le="20"} 2
+app_h2_bucket{service="test",l1="1",le="100"} 4
+app_h2_bucket{service="test",l1="1",le="+Inf"} 5
+app_h2_count{service="test",l1="1"} 5
+app_h2_sum{service="test",l1="1"} 226
+`)
+		require.NotContains(t, p.String(), "app_h2_p75")
+	})
+
+	t.Run("globally", func(t *testing.T) {
+		r := newRegistry()
+		p := service.NewPrometheusMetrics(r, "test")
+		p.NativeHistograms = true
+		require.NoError(t, p.Update())
+		require.Equal(t, `
+# TYPE app_h1 histogram
+app_h1_bucket{service="test",l1="1",le="10"} 1
+app_h1_bucket{service="test",l1="1",le="20"} 2
+app_h1_bucket{service="test",l1="1",le="100"} 4
+app_h1_bucket{service="test",l1="1",le="+Inf"} 5
+app_h1_count{service="test",l1="1"} 5
+app_h1_sum{service="test",l1="1"} 226
+
+# TYPE app_h2 histogram
+app_h2_bucket{service="test",l1="1",le="0.5"} 0
+app_h2_bucket{service="test",l1="1",le="10"} 1
+app_h2_bucket{service="test",l1="1",le="20"} 2
+app_h2_bucket{service="test",l1="1",le="100"} 4
+app_h2_bucket{service="test",l1="1",le="+Inf"} 5
+app_h2_count{service="test",l1="1"} 5
+app_h2_sum{service="test",l1="1"} 226
+`, p.String())
+
+		// counts and sums are cumulative
+		r.Get("app,l1=1 h1").(metrics.Histogram).Update(1)
+		require.NoError(t, p.Update())
+		require.Contains(t, p.String(), `app_h1_bucket{service="test",l1="1",le="+Inf"} 6
+app_h1_count{service="test",l1="1"} 6
+app_h1_sum{service="test",l1="1"} 227
+`)
+	})
+}
+
+func TestPrometheusMetrics_OpenMetrics(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("app,l1=2 c1", r).Inc(2)
+	metrics.GetOrRegisterGauge("app g1", r).Update(3)
+	h := metrics.GetOrRegisterHistogram("app,l1=1 h1", r, lft.NewLockFreeSampleWithBuckets([]float64{10, 20}))
+	h.Update(5)
+	h.Update(25)
+	metrics.GetOrRegisterCounter("app,bad c2", r)
+
+	p := service.NewPrometheusMetrics(r, "test")
+	require.Error(t, p.Update())
+
+	created := regexp.MustCompile(`(_created\{.*\}) \d+\.\d{3}\n`)
+	require.Equal(t, `# TYPE app_c1 counter
+app_c1_total{service="test",l1="2"} 2
+app_c1_created{service="test",l1="2"} <ts>
+# TYPE app_g1 gauge
+app_g1{service="test"} 3
+# TYPE app_h1 summary
+app_h1_count{service="test",l1="1"} 2
+app_h1_sum{service="test",l1="1"} 30
+app_h1{service="test",l1="1",quantile="0.5"} 15
+app_h1{service="test",l1="1",quantile="0.75"} 25
+app_h1{service="test",l1="1",quantile="0.95"} 25
+app_h1{service="test",l1="1",quantile="0.99"} 25
+app_h1{service="test",l1="1",quantile="0.999"} 25
+app_h1_created{service="test",l1="1"} <ts>
+# TYPE app_h1_buckets histogram
+app_h1_buckets_bucket{service="test",l1="1",le="10.000000"} 1
+app_h1_buckets_bucket{service="test",l1="1",le="20.000000"} 1
+app_h1_buckets_bucket{service="test",l1="1",le="+Inf"} 2
+app_h1_buckets_count{service="test",l1="1"} 2
+app_h1_buckets_sum{service="test",l1="1"} 30
+app_h1_buckets_created{service="test",l1="1"} <ts>
+# TYPE app_h1_max gauge
+app_h1_max{service="test",l1="1"} 25
+# TYPE app_h1_mean gauge
+app_h1_mean{service="test",l1="1"} 15
+# TYPE app_h1_min gauge
+app_h1_min{service="test",l1="1"} 5
+# TYPE app_h1_stddev gauge
+app_h1_stddev{service="test",l1="1"} 10
+# EOF
+`, created.ReplaceAllString(p.OpenMetricsString(), "$1 <ts>\n"))
+
+	t.Run("created timestamps don't change", func(t *testing.T) {
+		before := p.OpenMetricsString()
+		time.Sleep(10 * time.Millisecond)
+		require.Error(t, p.Update())
+		// the summary is gone as there were no new values
+		require.Equal(t, created.FindString(before), created.FindString(p.OpenMetricsString()))
+	})
+
+	t.Run("content negotiation", func(t *testing.T) {
+		for accept, expected := range map[string]string{
+			"":                             service.ContentTypePrometheusText,
+			"text/plain;version=0.0.4":     service.ContentTypePrometheusText,
+			"application/openmetrics-text": service.ContentTypeOpenMetrics,
+			// default of Prometheus 2.x
+			"application/openmetrics-text; version=0.0.1,text/plain;version=0.0.4;q=0.5,*/*;q=0.1": service.ContentTypeOpenMetrics,
+			"application/openmetrics-text;q=0.5,text/plain":                                        service.ContentTypePrometheusText,
+		} {
+			contentType, body := p.Negotiate(accept)
+			require.Equal(t, expected, contentType, accept)
+			if expected == service.ContentTypeOpenMetrics {
+				require.True(t, strings.HasSuffix(body, "# EOF\n"))
+			} else {
+				require.Equal(t, p.String(), body)
+			}
+		}
+	})
+}
+
+func TestPrometheusMetrics_Metadata(t *testing.T) {
+	r := metrics.NewRegistry()
+	md := service.NewMetricsMetadata()
+	metrics.GetOrRegisterCounter(md.Describe("app,l1=1 requests", "Number of\nrequests", ""), r).Inc(1)
+	metrics.GetOrRegisterCounter("app,l1=2 requests", r).Inc(2)
+	metrics.GetOrRegisterGauge(md.Describe("app size_bytes", `Size in "bytes"`, "bytes"), r).Update(3)
+	metrics.GetOrRegisterTimer(md.Describe("app latency", "Request latency", "nanoseconds"), r).Update(5)
+	metrics.GetOrRegisterGauge("app undescribed", r).Update(4)
+
+	p := service.NewPrometheusMetrics(r, "test")
+	p.Metadata = md
+	require.NoError(t, p.Update())
+
+	text := p.String()
+	assert.Contains(t, text, `
+# HELP app_requests_total Number of\nrequests
+# TYPE app_requests_total counter
+app_requests_total{service="test",l1="1"} 1
+app_requests_total{service="test",l1="2"} 2
+`)
+	assert.Contains(t, text, `
+# HELP app_size_bytes Size in "bytes"
+# TYPE app_size_bytes gauge
+# UNIT app_size_bytes bytes
+app_size_bytes{service="test"} 3
+`)
+	assert.Contains(t, text, `
+# HELP app_latency Request latency
+# TYPE app_latency summary
+# UNIT app_latency nanoseconds
+`)
+	assert.Contains(t, text, `
+# HELP app_latency_max Request latency (max)
+# TYPE app_latency_max gauge
+# UNIT app_latency_max nanoseconds
+`)
+	assert.Contains(t, text, `
+# TYPE app_undescribed gauge
+`)
+
+	om := p.OpenMetricsString()
+	assert.Contains(t, om, `# HELP app_requests Number of\nrequests
+# TYPE app_requests counter
+`)
+	assert.Contains(t, om, `# HELP app_size_bytes Size in \"bytes\"
+# TYPE app_size_bytes gauge
+# UNIT app_size_bytes bytes
+`)
+	// the family name doesn't end with the unit
+	assert.Contains(t, om, `# HELP app_latency Request latency
+# TYPE app_latency summary
+app_latency_count`)
+	assert.NotContains(t, om, "# UNIT app_latency")
+	assert.NotContains(t, om, "# HELP app_undescribed")
+}
+
+func TestPrometheusMetrics_StructuredLabels(t *testing.T) {
+	r := metrics.NewRegistry()
+	name := service.MetricName("act", "request", service.Label{Name: "partner", Value: `"Shopee", Inc.`},
+		service.Label{Name: "url", Value: "https://adclick.g.doubleclick.net/aclk?sa=L&x=100%"})
+	metrics.GetOrRegisterCounter(name, r).Inc(1)
+	metrics.GetOrRegisterCounter(service.MetricNameWithLabels("act", "escaped", map[string]string{
+		"path": `C:\tmp`, "line": "a\nb", "handler": "click",
+	}), r).Inc(2)
+	metrics.GetOrRegisterCounter(service.MetricName("act", "plain", service.Label{Name: "handler", Value: "click"}), r).Inc(3)
+
+	p := service.NewPrometheusMetrics(r, "test")
+	require.NoError(t, p.Update())
+
+	assert.Equal(t, "act,handler=click plain", service.MetricName("act", "plain", service.Label{Name: "handler", Value: "click"}))
+	assert.Equal(t, `
+# TYPE act_escaped_total counter
+act_escaped_total{service="test",handler="click",line="a\nb",path="C:\\tmp"} 2
+
+# TYPE act_plain_total counter
+act_plain_total{service="test",handler="click"} 3
+
+# TYPE act_request_total counter
+act_request_total{service="test",partner="\"Shopee\", Inc.",url="https://adclick.g.doubleclick.net/aclk?sa=L&x=100%"} 1
+`, p.String())
+	assert.Contains(t, p.OpenMetricsString(), `act_request_total{service="test",partner="\"Shopee\", Inc.",url="https://adclick.g.doubleclick.net/aclk?sa=L&x=100%"} 1`)
+
+	t.Run("invalid encoding", func(t *testing.T) {
+		r := metrics.NewRegistry()
+		metrics.GetOrRegisterCounter("act,partner=100%zz request", r).Inc(1)
+		metrics.GetOrRegisterCounter("act,partner=%FF request", r).Inc(1)
+		p := service.NewPrometheusMetrics(r, "test")
+		require.Error(t, p.Update())
+		assert.Contains(t, p.String(), `bad label value "partner=100%zz" in metric "act,partner=100%zz request": invalid URL escape "%zz"`)
+		assert.Contains(t, p.String(), `bad label value "partner=%FF" in metric "act,partner=%FF request": invalid UTF-8`)
+	})
+	t.Run("invalid label name", func(t *testing.T) {
+		for _, name := range []string{"", "a,b", "a=b", "a b"} {
+			assert.Panics(t, func() { service.MetricName("act", "request", service.Label{Name: name, Value: "x"}) }, name)
+		}
+	})
+}
+
 func TestPrometheusMetrics_Update(t *testing.T) {
 	t.Run(`empty`, func(t *testing.T) {
 		r := metrics.NewRegistry()
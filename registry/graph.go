@@ -0,0 +1,146 @@
+package registry
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// Graph describes all providers of a registry and how they depend on each other
+type Graph struct {
+	Providers []GraphProvider
+}
+
+// GraphProvider describes a single provider of a registry
+type GraphProvider struct {
+	// Provides is the type created by the constructor
+	Provides string
+	// Name is the name the provider was registered with
+	Name string `json:",omitempty"`
+	// Group is set for providers registered with RegisterGroup
+	Group bool `json:",omitempty"`
+	// Lifetime is set for providers that are not singletons
+	Lifetime string `json:",omitempty"`
+	// Requires are the types that need to be resolved before the constructor is called
+	Requires []GraphRequirement `json:",omitempty"`
+	// RequiresOnInstantiation are the lazy types that need to be passed to Request
+	RequiresOnInstantiation []string `json:",omitempty"`
+	// StaticArgs are the arguments given on Register
+	StaticArgs   []string `json:",omitempty"`
+	Instantiated bool
+}
+
+// GraphRequirement is a type required by a provider and the provider satisfying it, if any
+type GraphRequirement struct {
+	Type string
+	// Name is set if the requirement is satisfied by a named provider only
+	Name string `json:",omitempty"`
+	// Group is set if the requirement is satisfied by group providers. There is a requirement per
+	// group member.
+	Group bool `json:",omitempty"`
+	// Optional is set if the requirement doesn't need a provider
+	Optional   bool   `json:",omitempty"`
+	ProvidedBy string `json:",omitempty"`
+}
+
+// id is used to identify providers in the DOT output
+func (p GraphProvider) id() string {
+	return requirement{name: p.Name}.format(p.Provides)
+}
+
+// id is used to identify the required provider in the DOT output
+func (req GraphRequirement) id() string {
+	return requirement{name: req.Name}.format(req.Type)
+}
+
+// Graph returns the provider graph of the registry sorted by provided type
+func (r *Registry) Graph() *Graph {
+	g := &Graph{}
+	for _, p := range r.sortedProviders() {
+		gp := GraphProvider{
+			Provides:                p.provides.String(),
+			Name:                    p.name,
+			Group:                   p.group,
+			RequiresOnInstantiation: typeNames(p.requiresOnInstantiation),
+		}
+		if _, _, ok := r.instanceOf(p); ok {
+			gp.Instantiated = true
+		}
+		if p.lifetime != Singleton {
+			gp.Lifetime = p.lifetime.String()
+		}
+		for _, arg := range p.staticArgs {
+			gp.StaticArgs = append(gp.StaticArgs, fmt.Sprintf("%v", arg))
+		}
+		for _, dep := range r.dependencies(p) {
+			req := GraphRequirement{Type: dep.t.String(), Name: dep.name, Group: dep.group, Optional: dep.optional}
+			for _, member := range dep.providers {
+				req.ProvidedBy = member.String()
+				gp.Requires = append(gp.Requires, req)
+			}
+			if len(dep.providers) == 0 {
+				gp.Requires = append(gp.Requires, req)
+			}
+		}
+		g.Providers = append(g.Providers, gp)
+	}
+	return g
+}
+
+// WriteDOT writes the graph in the Graphviz DOT format. Instantiated providers are filled, lazy
+// requirements are dashed edges and requirements without a provider are drawn in red, or dotted if
+// they are optional.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph registry {\n")
+	b.WriteString("\tnode [shape=box];\n")
+	for _, p := range g.Providers {
+		label := p.id()
+		if p.Lifetime != "" {
+			label += "\\n" + p.Lifetime
+		}
+		if len(p.StaticArgs) > 0 {
+			label += "\\nargs: " + strings.Join(p.StaticArgs, ", ")
+		}
+		attrs := fmt.Sprintf("label=%s", dotQuote(label))
+		if p.Instantiated {
+			attrs += ", style=filled"
+		}
+		fmt.Fprintf(&b, "\t%s [%s];\n", dotQuote(p.id()), attrs)
+	}
+	for _, p := range g.Providers {
+		for _, req := range p.Requires {
+			switch {
+			case req.Group && req.ProvidedBy == "":
+				// empty group
+			case req.Optional && req.ProvidedBy == "":
+				fmt.Fprintf(&b, "\t%s -> %s [style=dotted];\n", dotQuote(p.id()), dotQuote(req.id()))
+			case req.ProvidedBy == "":
+				fmt.Fprintf(&b, "\t%s -> %s [color=red];\n", dotQuote(p.id()), dotQuote(req.id()))
+			case req.ProvidedBy != req.id():
+				fmt.Fprintf(&b, "\t%s -> %s [label=%s];\n", dotQuote(p.id()), dotQuote(req.ProvidedBy), dotQuote(req.Type))
+			default:
+				fmt.Fprintf(&b, "\t%s -> %s;\n", dotQuote(p.id()), dotQuote(req.ProvidedBy))
+			}
+		}
+		for _, t := range p.RequiresOnInstantiation {
+			fmt.Fprintf(&b, "\t%s -> %s [style=dashed];\n", dotQuote(p.id()), dotQuote(t))
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
+
+func typeNames(types []reflect.Type) []string {
+	var names []string
+	for _, t := range types {
+		names = append(names, t.String())
+	}
+	return names
+}
@@ -4,8 +4,13 @@ import (
 	"container/list"
 	"fmt"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/remerge/cue"
 )
 
@@ -15,32 +20,155 @@ type Registrar interface {
 
 // Registry is used to register service  constructors and instantiate the services.
 // It provides a tools for dependency inject based service composition.
+// It is safe to register and request concurrently. Every constructor of a Singleton or Scoped provider
+// is called only once, concurrent requests wait for the running constructor.
 type Registry struct {
-	providers map[reflect.Type]*provider
-	log       cue.Logger
+	mu        sync.RWMutex
+	providers map[providerKey]*provider
+	// groups holds the providers registered with RegisterGroup in registration order
+	groups []*provider
+	log    cue.Logger
+
+	// parent is set for scopes created with NewScope
+	parent *Registry
+	// scoped holds the instances of Scoped providers created in this scope
+	scoped map[*provider]*instance
+	// decorators are applied to the instances of the provider with the same key, see Decorate
+	decorators map[providerKey][]reflect.Value
+	// listeners are notified about every created singleton, see OnCreate
+	listeners []func(v interface{}, name string)
+	// constructions are the first maxConstructions constructor calls, see Constructions
+	constructions []Construction
+	tracers       []func(Construction)
+	// registrations counts the changes of providers and decorators, see version
+	registrations uint64
+	// cycleChecks caches the result of checkCycles per provider as cycleCheck
+	cycleChecks sync.Map
+}
+
+// Lifetime defines how long an instance created by a provider is used
+type Lifetime int
+
+const (
+	// Singleton providers create a single instance shared by the registry and all its scopes
+	Singleton Lifetime = iota
+	// Scoped providers create an instance per scope (see NewScope)
+	Scoped
+	// Transient providers create a new instance on every request
+	Transient
+)
+
+func (l Lifetime) String() string {
+	switch l {
+	case Singleton:
+		return "singleton"
+	case Scoped:
+		return "scoped"
+	case Transient:
+		return "transient"
+	}
+	return fmt.Sprintf("Lifetime(%d)", int(l))
+}
+
+// instance holds the value created by a Singleton or Scoped provider. mu is held while the value is
+// constructed, so it is only constructed once.
+type instance struct {
+	mu    sync.Mutex
+	built atomic.Value // *builtInstance
+}
+
+type builtInstance struct {
+	value        reflect.Value
+	dependencies []*provider
+}
+
+// get returns the constructed instance or nil if it wasn't constructed yet
+func (i *instance) get() *builtInstance {
+	b, _ := i.built.Load().(*builtInstance)
+	return b
+}
+
+// providerKey identifies a provider by its provided type and an optional name
+type providerKey struct {
+	t    reflect.Type
+	name string
 }
 
 // New create a new Registry
 func New() *Registry {
 	return &Registry{
-		providers: make(map[reflect.Type]*provider),
-		log:       cue.NewLogger("registry"),
+		providers:  make(map[providerKey]*provider),
+		log:        cue.NewLogger("registry"),
+		scoped:     make(map[*provider]*instance),
+		decorators: make(map[providerKey][]reflect.Value),
 	}
 }
 
+// NewScope creates a child registry of parent. The scope can request everything provided by parent and
+// shares the Singleton instances of parent. Scoped providers create a separate instance per scope.
+// Providers registered with the scope are not visible to parent.
+func NewScope(parent *Registry) *Registry {
+	r := New()
+	r.parent = parent
+	r.log = parent.log
+	return r
+}
+
 // Params is used to mark structs as ctor parameter holder
 type Params struct{}
 
-var paramsType = reflect.TypeOf(Params{})
+var (
+	paramsType = reflect.TypeOf(Params{})
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// requirement is a type required by a constructor. If name is set only the provider registered
+// with this name can satisfy it. A group requirement is a slice of all values provided by group
+// providers of the slice element type.
+type requirement struct {
+	t     reflect.Type
+	name  string
+	group bool
+	// noLifecycle is set if the consumer manages the lifecycle of the required value itself
+	noLifecycle bool
+	// optional requirements get their default value, or the zero value, if there is no provider
+	optional bool
+	def      reflect.Value
+}
+
+// missing returns the value used for an optional requirement without a provider
+func (req requirement) missing() reflect.Value {
+	if req.def.IsValid() {
+		return req.def
+	}
+	return reflect.Zero(req.t)
+}
+
+func (req requirement) String() string {
+	return req.format(req.t.String())
+}
+
+// format formats the required type name with the name of the requirement, e.g. *service.Server[admin]
+func (req requirement) format(typeName string) string {
+	if req.name == "" {
+		return typeName
+	}
+	return typeName + "[" + req.name + "]"
+}
 
 type provider struct {
-	requires                []reflect.Type
+	name                    string
+	group                   bool
+	lifetime                Lifetime
+	owner                   *Registry
+	requires                []requirement
 	expectedParamStruct     reflect.Type
 	requiresOnInstantiation []reflect.Type
 	provides                reflect.Type
 	ctor                    reflect.Value
-	instance                *reflect.Value
 	staticArgs              []interface{}
+	// singleton holds the instance of a Singleton provider
+	singleton instance
 }
 
 // Register registers a component constructor function with the registry. The constructor function can
@@ -52,6 +180,147 @@ type provider struct {
 //    are used as requirements for the ctor. This helps with ctor that require a large number of dependencies
 // 2) If there is an exact sub signature match with parameters passed to Request
 func (r *Registry) Register(ctor interface{}, args ...interface{}) (func(...interface{}) (interface{}, error), error) {
+	return r.RegisterNamed("", ctor, args...)
+}
+
+// RegisterNamed registers a constructor like Register but under a name. Multiple providers for the
+// same type can be registered with different names. A named provider is only used if it is requested
+// by name, either with RequestNamed or by a Params struct field tagged with `registry:"name=<name>"`.
+func (r *Registry) RegisterNamed(name string, ctor interface{}, args ...interface{}) (func(...interface{}) (interface{}, error), error) {
+	return r.register(name, Singleton, ctor, args)
+}
+
+// RegisterTransient registers a constructor like Register but the constructor is called on every request
+// and for every provider requiring its type.
+func (r *Registry) RegisterTransient(ctor interface{}, args ...interface{}) (func(...interface{}) (interface{}, error), error) {
+	return r.register("", Transient, ctor, args)
+}
+
+// RegisterScoped registers a constructor like Register but the instance is only shared within a scope.
+// Every scope created with NewScope gets its own instance, constructed from the providers visible to it.
+func (r *Registry) RegisterScoped(ctor interface{}, args ...interface{}) (func(...interface{}) (interface{}, error), error) {
+	return r.register("", Scoped, ctor, args)
+}
+
+// Override registers a constructor like Register but replaces the provider registered for the same type
+// before. The lifetime of the replaced provider is kept. Override fails if the replaced provider was
+// already instantiated. This is mainly useful in tests to replace real services by fakes.
+func (r *Registry) Override(ctor interface{}, args ...interface{}) (func(...interface{}) (interface{}, error), error) {
+	p, err := newProvider("", ctor, args)
+	if err != nil {
+		return nil, err
+	}
+	p.owner = r
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := providerKey{p.provides, ""}
+	if replaced, found := r.providers[key]; found {
+		if replaced.singleton.get() != nil {
+			return nil, fmt.Errorf("can not override %v, it was already instantiated", replaced)
+		}
+		p.lifetime = replaced.lifetime
+	}
+
+	r.log.Debugf("registered override for %v, requires=%v requiresOnInstantiation=%v", p, p.requires, p.requiresOnInstantiation)
+	r.providers[key] = p
+	r.registrations++
+	resolvedCtor := &ResolvedCtor{p, r}
+	return resolvedCtor.Call, nil
+}
+
+// Decorate registers a function of the form func(T) (T, error) that is applied to every instance created
+// by the provider of T, e.g. to wrap it. Decorators are applied in the order they were added and are
+// kept if the provider is replaced by Override. Decorate fails if there is no provider for T or if it
+// was already instantiated.
+func (r *Registry) Decorate(decorator interface{}) error {
+	t := reflect.TypeOf(decorator)
+	if t.Kind() != reflect.Func || t.NumIn() != 1 || t.NumOut() != 2 || t.Out(0) != t.In(0) || t.Out(1) != errorType {
+		return fmt.Errorf("Decorate expects a function of the form func(T) (T, error) not %v", t)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := providerKey{t.In(0), ""}
+	p, found := r.providers[key]
+	if !found {
+		return fmt.Errorf("no provider for %v to decorate", t.In(0))
+	}
+	if p.singleton.get() != nil {
+		return fmt.Errorf("can not decorate %v, it was already instantiated", p)
+	}
+	r.decorators[key] = append(r.decorators[key], reflect.ValueOf(decorator))
+	r.registrations++
+	return nil
+}
+
+// decorate applies the decorators of the provider to v
+func (r *Registry) decorate(p *provider, v reflect.Value) (reflect.Value, error) {
+	r.mu.RLock()
+	decorators := r.decorators[providerKey{p.provides, p.name}]
+	r.mu.RUnlock()
+
+	for _, d := range decorators {
+		res := d.Call([]reflect.Value{v})
+		if !res[1].IsNil() {
+			return reflect.Value{}, res[1].Interface().(error)
+		}
+		if res[0].IsNil() {
+			return reflect.Value{}, fmt.Errorf("The decorator %v return a nil value, this is not allowed", d.Type())
+		}
+		v = reflect.ValueOf(res[0].Interface())
+	}
+	return v, nil
+}
+
+func (r *Registry) register(name string, lifetime Lifetime, ctor interface{}, args []interface{}) (func(...interface{}) (interface{}, error), error) {
+	p, err := newProvider(name, ctor, args)
+	if err != nil {
+		return nil, err
+	}
+	p.lifetime = lifetime
+	p.owner = r
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := providerKey{p.provides, name}
+	if _, found := r.providers[key]; found {
+		return nil, fmt.Errorf("A provider for %v was already registered before", p)
+	}
+
+	r.log.Debugf("registered provider for %v, requires=%v requiresOnInstantiation=%v", p, p.requires, p.requiresOnInstantiation)
+	r.providers[key] = p
+	r.registrations++
+	resolvedCtor := &ResolvedCtor{p, r}
+	return resolvedCtor.Call, nil
+}
+
+// RegisterGroup registers a constructor contributing a value to a group. Any number of group providers
+// can be registered for the same type. Params struct fields of a slice type tagged with `registry:"group"`
+// receive the values of all group providers whose type is the slice element type or implements it.
+// Group providers are not used for any other requirement.
+func (r *Registry) RegisterGroup(ctor interface{}, args ...interface{}) (func(...interface{}) (interface{}, error), error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p, err := newProvider(fmt.Sprintf("#%d", len(r.groups)), ctor, args)
+	if err != nil {
+		return nil, err
+	}
+	p.group = true
+	p.owner = r
+
+	r.log.Debugf("registered group provider for %v, requires=%v requiresOnInstantiation=%v", p, p.requires, p.requiresOnInstantiation)
+	r.groups = append(r.groups, p)
+	r.registrations++
+	resolvedCtor := &ResolvedCtor{p, r}
+	return resolvedCtor.Call, nil
+}
+
+func newProvider(name string, ctor interface{}, args []interface{}) (*provider, error) {
 	t := reflect.TypeOf(ctor)
 
 	if t.Kind() != reflect.Func {
@@ -62,14 +331,9 @@ func (r *Registry) Register(ctor interface{}, args ...interface{}) (func(...inte
 		return nil, fmt.Errorf("Register expects a function that return two values not %d", t.NumOut())
 	}
 
-	provided := t.Out(0)
-
-	if _, found := r.providers[provided]; found {
-		return nil, fmt.Errorf("A provider for %v was already registered before", provided)
-	}
-
 	p := &provider{
-		provides:   provided,
+		name:       name,
+		provides:   t.Out(0),
 		ctor:       reflect.ValueOf(ctor),
 		staticArgs: args,
 	}
@@ -84,30 +348,46 @@ func (r *Registry) Register(ctor interface{}, args ...interface{}) (func(...inte
 		}
 		for i := 0; i < pt.NumField(); i++ {
 			f := pt.Field(i)
-			isLazyParam, _ := getRegistryTags(f)
-			if f.PkgPath == "" && f.Type != paramsType && !isLazyParam {
-				p.requires = append(p.requires, f.Type)
+			tags := getRegistryTags(f)
+			if tags.group && f.Type.Kind() != reflect.Slice {
+				return nil, fmt.Errorf("group field %s of %v needs to be a slice", f.Name, pt)
+			}
+			def, err := tags.defaultValue(f.Type)
+			if err != nil {
+				return nil, fmt.Errorf("invalid default for field %s of %v: %v", f.Name, pt, err)
 			}
-			if isLazyParam {
+			if f.PkgPath == "" && f.Type != paramsType && !tags.lazy {
+				p.requires = append(p.requires, requirement{
+					t:           f.Type,
+					name:        tags.name,
+					group:       tags.group,
+					noLifecycle: tags.noLifecycle,
+					optional:    tags.optional || tags.hasDefault,
+					def:         def,
+				})
+			}
+			if tags.lazy {
 				p.requiresOnInstantiation = append(p.requiresOnInstantiation, f.Type)
 			}
 		}
 	} else {
 		for i := 0; i < t.NumIn(); i++ {
-			p.requires = append(p.requires, t.In(i))
+			p.requires = append(p.requires, requirement{t: t.In(i)})
 		}
 	}
-	r.log.Debugf("registered provider for %v, requires=%v requiresOnInstantiation=%v", p.provides, p.requires, p.requiresOnInstantiation)
-	r.providers[p.provides] = p
-	resolvedCtor := &ResolvedCtor{p, r}
-	return resolvedCtor.Call, nil
+	return p, nil
 }
 
 // Request resolves a dependency tree for a given target type and sets up all objects on the way and creates an instance of targetType
 // targetType is the type of the requested object
 // params can be used to pass additional parameter structs.
 func (r *Registry) Request(targetType reflect.Type, params ...interface{}) (interface{}, error) {
-	p, err := r.providerFor(targetType)
+	return r.RequestNamed(targetType, "", params...)
+}
+
+// RequestNamed works like Request but uses the provider registered with the given name
+func (r *Registry) RequestNamed(targetType reflect.Type, name string, params ...interface{}) (interface{}, error) {
+	p, err := r.providerFor(requirement{t: targetType, name: name})
 	if err != nil {
 		return nil, err
 	}
@@ -118,6 +398,11 @@ func (r *Registry) Request(targetType reflect.Type, params ...interface{}) (inte
 // target needs to be a pointer to a pointer to the struct that should be initialized.
 // params can be used to pass additional parameter structs
 func (r *Registry) RequestAndSet(target interface{}, params ...interface{}) error {
+	return r.RequestAndSetNamed(target, "", params...)
+}
+
+// RequestAndSetNamed works like RequestAndSet but uses the provider registered with the given name
+func (r *Registry) RequestAndSetNamed(target interface{}, name string, params ...interface{}) error {
 	// must be a pointer to a pointer
 	pt := reflect.TypeOf(target)
 
@@ -133,7 +418,7 @@ func (r *Registry) RequestAndSet(target interface{}, params ...interface{}) erro
 		return fmt.Errorf("Dereferenced target needs to be a pointer but is %v", t)
 	}
 
-	v, err := r.Request(t, params...)
+	v, err := r.RequestNamed(t, name, params...)
 	if err != nil {
 		return err
 	}
@@ -142,53 +427,222 @@ func (r *Registry) RequestAndSet(target interface{}, params ...interface{}) erro
 	return nil
 }
 
-func (r *Registry) interfaceFor(p *provider, params []interface{}) (interface{}, error) {
-	if p.instance == nil {
+// Dependencies returns all instances the given instance was constructed from. The list contains direct
+// as well as transitive dependencies and is ordered so that every instance appears after its own dependencies.
+// If the instance was not created by the registry nil is returned.
+func (r *Registry) Dependencies(instance interface{}) []interface{} {
+	p := r.providerForInstance(instance)
+	if p == nil {
+		return nil
+	}
+	var deps []interface{}
+	visited := map[*provider]bool{p: true}
+	var walk func(p *provider)
+	walk = func(p *provider) {
+		_, dependencies, _ := r.instanceOf(p)
+		for _, dep := range dependencies {
+			if visited[dep] {
+				continue
+			}
+			visited[dep] = true
+			walk(dep)
+			if v, _, ok := r.instanceOf(dep); ok {
+				deps = append(deps, v.Interface())
+			}
+		}
+	}
+	walk(p)
+	return deps
+}
 
-		// join in any provider static args
-		for _, arg := range p.staticArgs {
-			params = append(params, arg)
+func (r *Registry) providerForInstance(instance interface{}) *provider {
+	if instance == nil || !reflect.TypeOf(instance).Comparable() {
+		return nil
+	}
+	for _, p := range r.allProviders() {
+		if v, _, ok := r.instanceOf(p); ok && v.Interface() == instance {
+			return p
 		}
+	}
+	return nil
+}
+
+// instanceOf returns the instance of p visible to this scope and the providers it was constructed from.
+// Transient providers never have an instance.
+func (r *Registry) instanceOf(p *provider) (reflect.Value, []*provider, bool) {
+	var i *instance
+	switch p.lifetime {
+	case Singleton:
+		i = &p.singleton
+	case Scoped:
+		r.mu.RLock()
+		i = r.scoped[p]
+		r.mu.RUnlock()
+	}
+	if i == nil {
+		return reflect.Value{}, nil, false
+	}
+	if b := i.get(); b != nil {
+		return b.value, b.dependencies, true
+	}
+	return reflect.Value{}, nil, false
+}
 
-		if err := r.resolve(p, params); err != nil {
-			r.log.Debugf("could not resolve %v params=%v err=%v", p.ctor.Type(), params, err)
-			return nil, err
+// instanceFor returns the instance of p for this scope, nil for Transient providers
+func (r *Registry) instanceFor(p *provider) *instance {
+	switch p.lifetime {
+	case Singleton:
+		return &p.singleton
+	case Scoped:
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		i, found := r.scoped[p]
+		if !found {
+			i = &instance{}
+			r.scoped[p] = i
 		}
+		return i
 	}
-	return p.instance.Interface(), nil
+	return nil
 }
 
-func (r *Registry) providerFor(t reflect.Type) (*provider, error) {
-	r.log.Debugf("requesting provider for %v", t)
-	p, found := r.providers[t]
-	if !found {
-		pi, err := r.findProviderForInterface(t)
-		if err != nil {
-			return nil, err
+// version changes with every registration visible to this scope
+func (r *Registry) version() uint64 {
+	var version uint64
+	for s := r; s != nil; s = s.parent {
+		s.mu.RLock()
+		version += s.registrations
+		s.mu.RUnlock()
+	}
+	return version
+}
+
+// allProviders returns the providers visible to this scope including the group providers
+func (r *Registry) allProviders() []*provider {
+	var providers []*provider
+	for s := r; s != nil; s = s.parent {
+		s.mu.RLock()
+		for _, p := range s.providers {
+			providers = append(providers, p)
 		}
-		if pi != nil {
-			r.log.Debugf("%v is an interface provided by %v", t, pi.provides)
+		providers = append(providers, s.groups...)
+		s.mu.RUnlock()
+	}
+	return providers
+}
+
+// groupProviders returns the group providers contributing to the required slice type. Group providers
+// of parent scopes come first.
+func (r *Registry) groupProviders(req requirement) []*provider {
+	var groups []*provider
+	for s := r; s != nil; s = s.parent {
+		s.mu.RLock()
+		groups = append(s.groups[:len(s.groups):len(s.groups)], groups...)
+		s.mu.RUnlock()
+	}
+	elem := req.t.Elem()
+	var members []*provider
+	for _, p := range groups {
+		if p.provides == elem || (elem.Kind() == reflect.Interface && p.provides.Implements(elem)) {
+			members = append(members, p)
 		}
-		p = pi
+	}
+	return members
+}
+
+func (r *Registry) interfaceFor(p *provider, params []interface{}) (interface{}, error) {
+	if err := r.checkCycles(p); err != nil {
+		return nil, err
+	}
+	params = joinStaticArgs(p, params)
+	v, err := r.resolve(p, params, nil, false)
+	if err != nil {
+		r.log.Debugf("could not resolve %v params=%v err=%v", p.ctor.Type(), params, err)
+		return nil, err
+	}
+	return v.Interface(), nil
+}
+
+func (r *Registry) providerFor(req requirement) (*provider, error) {
+	r.log.Debugf("requesting provider for %v", req)
+	p, err := r.lookup(req)
+	if err != nil {
+		return nil, err
 	}
 	if p == nil {
-		return nil, fmt.Errorf("no provider for %v", t)
+		return nil, fmt.Errorf("no provider for %v", req)
 	}
 	return p, nil
 }
 
-// resolve is recursive - it doesn't build a proper graph at the moment
-// This should be sufficient for our usecases at the moment
-func (r *Registry) resolve(p *provider, extraParams []interface{}) error {
+// lookup returns the provider for the required type. If there is none but the type is an interface
+// the provider of the type implementing it is returned. Providers of this scope take precedence over
+// the ones of parent scopes. lookup returns nil if there is no provider.
+func (r *Registry) lookup(req requirement) (*provider, error) {
+	for s := r; s != nil; s = s.parent {
+		if p, err := s.lookupLocal(req); p != nil || err != nil {
+			return p, err
+		}
+	}
+	return nil, nil
+}
+
+// lookupLocal works like lookup but only considers the providers of this scope
+func (r *Registry) lookupLocal(req requirement) (*provider, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if p, found := r.providers[providerKey{req.t, req.name}]; found {
+		return p, nil
+	}
+	// t might be an interface, lets scan all provider - maybe there is one that implements it?
+	p, err := r.findProviderForInterface(req)
+	if p != nil {
+		r.log.Debugf("%v is an interface provided by %v", req, p)
+	}
+	return p, err
+}
+
+// resolve is recursive - it doesn't build a proper graph at the moment (see Validate for that)
+// This should be sufficient for our usecases at the moment. path holds the providers currently
+// being resolved and is used to detect dependency cycles. resolve returns the instance of p for
+// this scope and creates it and its dependencies if needed. If noLifecycle is set the listeners are not
+// notified if the instance is created.
+func (r *Registry) resolve(p *provider, extraParams []interface{}, path []*provider, noLifecycle bool) (reflect.Value, error) {
 	r.log.Debugf("resolving %v, requires=%v extraParams=%v", p.provides, p.requires, extraParams)
 
-	if p.instance != nil {
-		r.log.Debugf("returning previously created instance=%v", p.instance)
-		return nil
+	if p.lifetime == Singleton && p.owner != r {
+		// singletons are shared and only depend on what is visible to the scope they were registered with
+		return p.owner.resolve(p, extraParams, path, noLifecycle)
+	}
+
+	i := r.instanceFor(p)
+	if i != nil {
+		if b := i.get(); b != nil {
+			r.log.Debugf("returning previously created instance=%v", b.value)
+			return b.value, nil
+		}
+	}
+
+	// check for cycles before waiting for the instance, a cycle would dead lock otherwise
+	for idx, resolving := range path {
+		if resolving == p {
+			return reflect.Value{}, cycleError(append(path[idx:len(path):len(path)], p))
+		}
+	}
+
+	if i != nil {
+		i.mu.Lock()
+		defer i.mu.Unlock()
+		// the instance might have been created while waiting
+		if b := i.get(); b != nil {
+			return b.value, nil
+		}
 	}
+	path = append(path, p)
 
 	var params []reflect.Value
 	var filteredExtraParams []interface{}
+	var dependencies []*provider
 
 	for _, param := range extraParams {
 		for _, requiredOnInstantiation := range p.requiresOnInstantiation {
@@ -198,17 +652,31 @@ func (r *Registry) resolve(p *provider, extraParams []interface{}) error {
 		}
 	}
 
-	for idx, t := range p.requires {
-		r.log.Debugf("walking requires for %v require=%v extraParams=%v", p.ctor.Type(), t, extraParams)
-		provider2, found := r.providers[t]
-		if !found {
-			r.log.Debugf("no direct provider for %v, is interface=%t (kind=%v)", t, t.Kind() == reflect.Interface, t.Kind())
-			// t might be an interface, lets scan all provider - maybe there is one that implements it?
-			var err error
-			provider2, err = r.findProviderForInterface(t)
-			if err != nil {
-				return err
+	for idx, req := range p.requires {
+		r.log.Debugf("walking requires for %v require=%v extraParams=%v", p.ctor.Type(), req, extraParams)
+		if req.group {
+			group := reflect.MakeSlice(req.t, 0, 0)
+			for _, member := range r.groupProviders(req) {
+				v, err := r.resolve(member, joinStaticArgs(member, extraParams), path, req.noLifecycle)
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				group = reflect.Append(group, v)
+				dependencies = append(dependencies, member)
 			}
+			params = append(params, group)
+			continue
+		}
+		provider2, err := r.lookup(req)
+		if err != nil {
+			return reflect.Value{}, resolveError(path, err)
+		}
+		if provider2 == nil && req.optional {
+			params = append(params, req.missing())
+			continue
+		}
+		if provider2 == nil && req.name != "" {
+			return reflect.Value{}, r.missing(req, path, fmt.Errorf("no provider for %v, required by %v", req, p.ctor.Type()))
 		}
 		if provider2 == nil {
 			// t was not an interface or no provided type implements t
@@ -216,7 +684,7 @@ func (r *Registry) resolve(p *provider, extraParams []interface{}) error {
 			// instead of a type a function that returns the type is supported as well and will be called
 			// this is a special case and we will terminate the loop for this
 			if !exactSubSignatureMatch(p.ctor.Type(), idx, extraParams) {
-				return fmt.Errorf("no provider for %v (and no exact signature match), required by %v", t, p.ctor.Type())
+				return reflect.Value{}, r.missing(req, path, fmt.Errorf("no provider for %v (and no exact signature match), required by %v", req, p.ctor.Type()))
 			}
 			r.log.Debugf("exact subtype match %v idx=%v extraParams=%v", p.ctor.Type(), idx, extraParams)
 			// we might have one that is a function, replace it with its value
@@ -230,7 +698,7 @@ func (r *Registry) resolve(p *provider, extraParams []interface{}) error {
 						funcParamType := typeOfParam.In(paramIdx)
 						instance, err := r.Request(funcParamType)
 						if err != nil {
-							return fmt.Errorf("instance request failed for %v (required as function argument during exact sub signature match by %v)", funcParamType, typeOfParam)
+							return reflect.Value{}, fmt.Errorf("instance request failed for %v (required as function argument during exact sub signature match by %v)", funcParamType, typeOfParam)
 						}
 						paramsForFunc = append(paramsForFunc, reflect.ValueOf(instance))
 					}
@@ -241,10 +709,12 @@ func (r *Registry) resolve(p *provider, extraParams []interface{}) error {
 			filteredExtraParams = extraParams
 			break
 		}
-		if err := r.resolve(provider2, extraParams); err != nil {
-			return err
+		v, err := r.resolve(provider2, joinStaticArgs(provider2, extraParams), path, req.noLifecycle)
+		if err != nil {
+			return reflect.Value{}, err
 		}
-		params = append(params, *provider2.instance)
+		params = append(params, v)
+		dependencies = append(dependencies, provider2)
 	}
 
 	// lets attach all extra params
@@ -253,15 +723,67 @@ func (r *Registry) resolve(p *provider, extraParams []interface{}) error {
 	}
 	r.log.Debugf("filtered extra params are %v ", filteredExtraParams)
 
-	return r.instantiate(p, params)
+	started := time.Now()
+	v, err := r.instantiate(p, params)
+	if err == nil {
+		v, err = p.owner.decorate(p, v)
+	}
+	r.trace(p, path, started, err)
+	if err != nil {
+		return reflect.Value{}, resolveError(path, err)
+	}
+	if i != nil {
+		i.built.Store(&builtInstance{value: v, dependencies: dependencies})
+	}
+	if p.lifetime == Singleton && !noLifecycle {
+		r.notifyCreated(p, v)
+	}
+	return v, nil
+}
+
+// resolveError adds the resolution path to err, path ends with the provider that failed
+func resolveError(path []*provider, err error) error {
+	names := make([]string, len(path))
+	for i, p := range path {
+		names[i] = p.String()
+	}
+	return errors.Wrapf(err, "resolving %s", strings.Join(names, " -> "))
+}
+
+// OnCreate registers a function that is called for every Singleton created by the registry with the
+// created value and the name of its provider. Values are created after their dependencies, so the
+// calls are in dependency order. Values required by a Params struct field tagged with
+// `registry:"nolifecycle"` are not reported if they are created for this field, the consumer is
+// responsible for them. Scoped and Transient instances are never reported.
+func (r *Registry) OnCreate(fn func(v interface{}, name string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.listeners = append(r.listeners, fn)
 }
 
-func (r *Registry) findProviderForInterface(t reflect.Type) (p *provider, err error) {
+func (r *Registry) notifyCreated(p *provider, v reflect.Value) {
+	r.mu.RLock()
+	listeners := r.listeners
+	r.mu.RUnlock()
+
+	for _, fn := range listeners {
+		fn(v.Interface(), p.name)
+	}
+}
+
+// findProviderForInterface returns the provider with the given name of the only type implementing
+// the required interface
+func (r *Registry) findProviderForInterface(req requirement) (p *provider, err error) {
+	t := req.t
 	if t.Kind() != reflect.Interface {
 		return nil, nil
 	}
 	var implementor reflect.Type
-	for providedType, provider2 := range r.providers {
+	for key, provider2 := range r.providers {
+		providedType := key.t
+		if key.name != req.name {
+			continue
+		}
 		// r.log.Debugf("%v implements %v = %t", providedType, t, providedType.Implements(t))
 		if providedType.Implements(t) {
 			if implementor != nil {
@@ -282,24 +804,26 @@ func mapToValue(values []interface{}) (r []reflect.Value) {
 	return r
 }
 
-func (r *Registry) instantiate(p *provider, params []reflect.Value) error {
+func (r *Registry) instantiate(p *provider, params []reflect.Value) (reflect.Value, error) {
 	r.log.Debugf("instantiate %v with %v", p.provides, params)
 
 	if p.expectedParamStruct != nil {
-		params = []reflect.Value{createParamStruct(p.expectedParamStruct, params)}
+		ps, err := createParamStruct(p.expectedParamStruct, params)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		params = []reflect.Value{ps}
 	}
 
 	res := p.ctor.Call(params)
 	if !res[1].IsNil() {
-		return res[1].Interface().(error)
+		return reflect.Value{}, res[1].Interface().(error)
 	}
 	if res[0].IsNil() {
-		return fmt.Errorf("The constructor %v return a nil value, this is not allowed", p.ctor.Type())
+		return reflect.Value{}, fmt.Errorf("The constructor %v return a nil value, this is not allowed", p.ctor.Type())
 	}
 
-	v := reflect.ValueOf(res[0].Interface())
-	p.instance = &v
-	return nil
+	return reflect.ValueOf(res[0].Interface()), nil
 }
 
 // Ctor exposes the constructor function with a reference to the registry so it can be
@@ -314,12 +838,18 @@ func (ctor *ResolvedCtor) Call(params ...interface{}) (interface{}, error) {
 	return ctor.r.interfaceFor(ctor.p, params)
 }
 
-func createParamStruct(t reflect.Type, params []reflect.Value) reflect.Value {
+// createParamStruct sets the fields of a new Params struct of type t to the given params. Fields
+// without a param are left at their zero value or set to their default value if they are tagged
+// with `registry:"optional"` or `registry:"default=..."`.
+func createParamStruct(t reflect.Type, params []reflect.Value) (reflect.Value, error) {
 	if t.Kind() == reflect.Ptr {
 		t = t.Elem()
 	}
 	paramsStructPtr := reflect.New(t)
 	paramsStruct := paramsStructPtr.Elem()
+	// every param is used once, params are in field order so fields of the same type (e.g. named
+	// providers) get the right value
+	used := make([]bool, len(params))
 	// not performance critical so we just do the o^2 approach
 	for i := 0; i < paramsStruct.NumField(); i++ {
 		f := paramsStruct.Field(i)
@@ -330,37 +860,41 @@ func createParamStruct(t reflect.Type, params []reflect.Value) reflect.Value {
 			continue
 		}
 		found := false
-		for _, p := range params {
+		for j, p := range params {
+			if used[j] {
+				continue
+			}
 			// not 100% correct as we don't handle the case if multiple registered objects
 			// implement the same interface - for now we can ignore this
 			if p.Type() == f.Type() || (f.Kind() == reflect.Interface && p.Type().Implements(f.Type())) {
 				f.Set(p)
+				used[j] = true
 				found = true
 				break
 			}
 		}
 		if !found {
 			structField := paramsStruct.Type().Field(i)
-			structFieldType := structField.Type
-			if structFieldType.Kind() == reflect.Ptr {
-				structFieldType = structFieldType.Elem()
-			}
-			isStruct := structFieldType.Kind() == reflect.Struct
-			if !isStruct {
-				panic("could not find struct param " + f.Type().String() + " for " + t.String())
-			}
-			// if it is a point we might allow nil values
-			_, allowNil := getRegistryTags(structField)
-			if !allowNil {
-				panic("could not find struct param " + f.Type().String() + " for " + t.String())
+			tags := getRegistryTags(structField)
+			def, err := tags.defaultValue(f.Type())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("invalid default for field %s of %v: %v", structField.Name, t, err)
 			}
-			if f.Type().Kind() != reflect.Ptr {
-				panic("only param struct fields of type Pointer can be tagged as 'allownnil'. Type is " + f.Type().String() + " for " + t.String())
+			switch {
+			case def.IsValid():
+				f.Set(def)
+			case tags.optional:
+				// we leave it at the zero value
+			case tags.allowNil && f.Kind() == reflect.Ptr:
+				// we leave it at null value
+			case tags.allowNil:
+				return reflect.Value{}, fmt.Errorf("only param struct fields of type Pointer can be tagged as 'allownil'. Type is %v for %v", f.Type(), t)
+			default:
+				return reflect.Value{}, fmt.Errorf("could not find struct param %v for %v", f.Type(), t)
 			}
-			// we leave it at null value
 		}
 	}
-	return paramsStructPtr
+	return paramsStructPtr, nil
 }
 
 func embedsType(embedder, embedded reflect.Type) bool {
@@ -410,12 +944,104 @@ func exactSubSignatureMatch(ctorType reflect.Type, idx int, params []interface{}
 	return true
 }
 
-func getRegistryTags(field reflect.StructField) (isLazy, allowNil bool) {
+// registryTags are the options of a Params struct field given by the registry tag, e.g.
+// `registry:"lazy,allownil"`, `registry:"name=admin"`, `registry:"group"`, `registry:"nolifecycle"`,
+// `registry:"optional"` or `registry:"default=10s"`. The default value is the rest of the tag, so
+// default needs to be the last option.
+type registryTags struct {
+	lazy        bool
+	allowNil    bool
+	group       bool
+	noLifecycle bool
+	optional    bool
+	hasDefault  bool
+	def         string
+	name        string
+}
+
+func getRegistryTags(field reflect.StructField) (tags registryTags) {
 	tag, found := field.Tag.Lookup("registry")
 	if !found || tag == "" {
-		return false, false
+		return tags
+	}
+	options := strings.Split(tag, ",")
+	for i, option := range options {
+		option = strings.TrimSpace(option)
+		if strings.HasPrefix(option, "default=") {
+			tags.hasDefault = true
+			tags.def = strings.TrimPrefix(strings.TrimLeft(strings.Join(options[i:], ","), " "), "default=")
+			break
+		}
+		switch {
+		case option == "lazy":
+			tags.lazy = true
+		case option == "allownil":
+			tags.allowNil = true
+		case option == "group":
+			tags.group = true
+		case option == "nolifecycle":
+			tags.noLifecycle = true
+		case option == "optional":
+			tags.optional = true
+		case strings.HasPrefix(option, "name="):
+			tags.name = strings.TrimPrefix(option, "name=")
+		}
 	}
-	isLazy = strings.Contains(tag, "lazy")
-	allowNil = strings.Contains(tag, "allownil")
-	return isLazy, allowNil
+	return tags
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// defaultValue parses the default value of the tag for a field of type t. It returns an invalid
+// value if there is no default. Defaults are supported for strings, bools, numbers and durations.
+func (tags registryTags) defaultValue(t reflect.Type) (reflect.Value, error) {
+	if !tags.hasDefault {
+		return reflect.Value{}, nil
+	}
+	v := reflect.New(t).Elem()
+	var err error
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString(tags.def)
+	case reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(tags.def)
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		if t == durationType {
+			var d time.Duration
+			d, err = time.ParseDuration(tags.def)
+			i = int64(d)
+		} else {
+			i, err = strconv.ParseInt(tags.def, 0, t.Bits())
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var u uint64
+		u, err = strconv.ParseUint(tags.def, 0, t.Bits())
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		f, err = strconv.ParseFloat(tags.def, t.Bits())
+		v.SetFloat(f)
+	default:
+		return reflect.Value{}, fmt.Errorf("default values are not supported for %v", t)
+	}
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	return v, nil
+}
+
+// joinStaticArgs returns params followed by the static args of the provider
+func joinStaticArgs(p *provider, params []interface{}) []interface{} {
+	if len(p.staticArgs) == 0 {
+		return params
+	}
+	return append(append([]interface{}(nil), params...), p.staticArgs...)
+}
+
+func (p *provider) String() string {
+	return requirement{t: p.provides, name: p.name}.String()
 }
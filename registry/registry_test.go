@@ -1,8 +1,13 @@
 package registry
 
 import (
+	"errors"
 	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/d4l3k/messagediff"
 	"github.com/remerge/cue"
@@ -361,6 +366,25 @@ func TestServiceRegistry(t *testing.T) {
 		// require.Equal(t, target.B.String, "hallo")
 		// require.Equal(t, target.B.Int, 42)
 	})
+	t.Run("dependencies of an instance", func(t *testing.T) {
+		r := New()
+
+		type A struct{}
+		type B struct{ A *A }
+		type C struct{ B *B }
+		type D struct{}
+
+		assert.NoError(t, register(r, func() (*A, error) { return &A{}, nil }))
+		assert.NoError(t, register(r, func(a *A) (*B, error) { return &B{A: a}, nil }))
+		assert.NoError(t, register(r, func(b *B) (*C, error) { return &C{B: b}, nil }))
+
+		var c *C
+		require.NoError(t, r.RequestAndSet(&c))
+		assert.Equal(t, []interface{}{c.B.A, c.B}, r.Dependencies(c))
+		assert.Equal(t, []interface{}{c.B.A}, r.Dependencies(c.B))
+		assert.Empty(t, r.Dependencies(c.B.A))
+		assert.Nil(t, r.Dependencies(&D{}))
+	})
 	t.Run("ctor Call method", func(t *testing.T) {
 		r := New()
 
@@ -417,10 +441,633 @@ func TestCreateParamStruct(t *testing.T) {
 			vv = append(vv, reflect.ValueOf(v))
 		}
 		typ := reflect.TypeOf(e.targetStruct)
-		ps := createParamStruct(typ, vv)
+		ps, err := createParamStruct(typ, vv)
+		require.NoError(t, err)
 		if !reflect.DeepEqual(e.targetStruct, ps.Interface()) {
 			diff, _ := messagediff.PrettyDiff(e.targetStruct, ps.Interface())
 			t.Errorf("objects (%s) don't match but should:\n%v\n", typ, diff)
 		}
 	}
 }
+
+func TestValidate(t *testing.T) {
+	type B struct{ a *A }
+	type C struct{ b *B }
+	type D struct{}
+
+	t.Run("valid graph", func(t *testing.T) {
+		r := New()
+		_, err := r.Register(func() (*A, error) { return &A{}, nil })
+		require.NoError(t, err)
+		_, err = r.Register(func(a IA, name string) (*B, error) { return &B{}, nil }, "b")
+		require.NoError(t, err)
+		require.NoError(t, r.Validate())
+	})
+
+	t.Run("params passed to request", func(t *testing.T) {
+		r := New()
+		_, err := r.Register(func() (*A, error) { return &A{}, nil })
+		require.NoError(t, err)
+		_, err = r.Register(func(a *A, n int, name string) (*B, error) { return &B{a}, nil }, "b")
+		require.NoError(t, err)
+		require.NoError(t, r.Validate())
+		_, err = r.Request(reflect.TypeOf(&B{}), 42)
+		require.NoError(t, err)
+	})
+
+	t.Run("missing params struct field", func(t *testing.T) {
+		r := New()
+		_, err := r.Register(func() (*A, error) { return &A{}, nil })
+		require.NoError(t, err)
+		_, err = r.Register(func(p *struct {
+			Params
+			A *A
+			D *D
+			C *C
+		}) (*B, error) {
+			return &B{}, nil
+		})
+		require.NoError(t, err)
+		err = r.Validate()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "no provider for *registry.D")
+		require.Contains(t, err.Error(), "no provider for *registry.C")
+		_, err = r.Request(reflect.TypeOf(&B{}))
+		require.Error(t, err)
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		r := New()
+		_, err := r.Register(func(c *C) (*A, error) { return &A{}, nil })
+		require.NoError(t, err)
+		_, err = r.Register(func(a *A) (*B, error) { return &B{a}, nil })
+		require.NoError(t, err)
+		_, err = r.Register(func(b *B) (*C, error) { return &C{b}, nil })
+		require.NoError(t, err)
+
+		err = r.Validate()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "dependency cycle: *registry.A -> *registry.C -> *registry.B -> *registry.A")
+
+		_, err = r.Request(reflect.TypeOf(&B{}))
+		require.EqualError(t, err, "dependency cycle: *registry.B -> *registry.A -> *registry.C -> *registry.B")
+	})
+
+	t.Run("missing provider", func(t *testing.T) {
+		r := New()
+		_, err := r.Register(func() (*B, error) { return &B{}, nil })
+		require.NoError(t, err)
+		_, err = r.Register(func(d *D, b *B) (*A, error) { return &A{}, nil })
+		require.NoError(t, err)
+		err = r.Validate()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "no provider for *registry.D, required by func(*registry.D, *registry.B) (*registry.A, error)")
+	})
+
+	t.Run("ambiguous interface", func(t *testing.T) {
+		r := New()
+		type A2 struct{ A }
+		_, err := r.Register(func() (*A, error) { return &A{}, nil })
+		require.NoError(t, err)
+		_, err = r.Register(func() (*A2, error) { return &A2{}, nil })
+		require.NoError(t, err)
+		_, err = r.Register(func(a IA) (*B, error) { return &B{}, nil })
+		require.NoError(t, err)
+		err = r.Validate()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "Multiple types")
+		require.Contains(t, err.Error(), "required by func(registry.IA) (*registry.B, error)")
+	})
+}
+
+func TestGraph(t *testing.T) {
+	type B struct{}
+	type C struct{}
+	type D struct{}
+	type P struct {
+		Params
+		IA
+		D *D
+		C *C `registry:"lazy"`
+	}
+
+	r := New()
+	_, err := r.Register(func() (*A, error) { return &A{}, nil })
+	require.NoError(t, err)
+	_, err = r.Register(func(p *P) (*B, error) { return &B{}, nil })
+	require.NoError(t, err)
+	_, err = r.Register(func(a *A, d *D, name string) (*C, error) { return &C{}, nil }, "c")
+	require.NoError(t, err)
+	_, err = r.Request(reflect.TypeOf(&A{}))
+	require.NoError(t, err)
+
+	g := r.Graph()
+	require.Equal(t, &Graph{Providers: []GraphProvider{
+		{Provides: "*registry.A", Instantiated: true},
+		{
+			Provides:                "*registry.B",
+			Requires:                []GraphRequirement{{Type: "registry.IA", ProvidedBy: "*registry.A"}, {Type: "*registry.D"}},
+			RequiresOnInstantiation: []string{"*registry.C"},
+		},
+		// the trailing *registry.D is passed to Request
+		{
+			Provides:   "*registry.C",
+			Requires:   []GraphRequirement{{Type: "*registry.A", ProvidedBy: "*registry.A"}},
+			StaticArgs: []string{"c"},
+		},
+	}}, g)
+
+	var b strings.Builder
+	require.NoError(t, g.WriteDOT(&b))
+	require.Equal(t, `digraph registry {
+	node [shape=box];
+	"*registry.A" [label="*registry.A", style=filled];
+	"*registry.B" [label="*registry.B"];
+	"*registry.C" [label="*registry.C\nargs: c"];
+	"*registry.B" -> "*registry.A" [label="registry.IA"];
+	"*registry.B" -> "*registry.D" [color=red];
+	"*registry.B" -> "*registry.C" [style=dashed];
+	"*registry.C" -> "*registry.A";
+}
+`, b.String())
+}
+
+func TestNamedProviders(t *testing.T) {
+	type Server struct{ Port int }
+	type Servers struct {
+		Params
+		Public *Server
+		Admin  *Server `registry:"name=admin"`
+		IA     IA
+	}
+	type Target struct{ Public, Admin *Server }
+
+	r := New()
+	_, err := r.Register(func() (*Server, error) { return &Server{Port: 80}, nil })
+	require.NoError(t, err)
+	_, err = r.RegisterNamed("admin", func(port int) (*Server, error) { return &Server{Port: port}, nil }, 8080)
+	require.NoError(t, err)
+	_, err = r.RegisterNamed("admin", func() (*Server, error) { return &Server{}, nil })
+	require.EqualError(t, err, "A provider for *registry.Server[admin] was already registered before")
+
+	// named implementors are ignored when looking up an interface
+	_, err = r.Register(func() (*A, error) { return &A{}, nil })
+	require.NoError(t, err)
+	_, err = r.RegisterNamed("other", func() (*A, error) { return &A{}, nil })
+	require.NoError(t, err)
+
+	_, err = r.Register(func(p *Servers) (*Target, error) { return &Target{p.Public, p.Admin}, nil })
+	require.NoError(t, err)
+	require.NoError(t, r.Validate())
+
+	target := &Target{}
+	require.NoError(t, r.RequestAndSet(&target))
+	require.Equal(t, 80, target.Public.Port)
+	require.Equal(t, 8080, target.Admin.Port)
+
+	admin, err := r.RequestNamed(reflect.TypeOf(&Server{}), "admin")
+	require.NoError(t, err)
+	require.Equal(t, target.Admin, admin)
+
+	_, err = r.RequestNamed(reflect.TypeOf(&Server{}), "internal")
+	require.EqualError(t, err, "no provider for *registry.Server[internal]")
+}
+
+type namedA struct{ name string }
+
+func (a *namedA) M() {}
+
+func TestGroupProviders(t *testing.T) {
+	type Target struct{ All []IA }
+	type TargetParams struct {
+		Params
+		All []IA `registry:"group"`
+	}
+
+	r := New()
+	_, err := r.RegisterGroup(func() (*A, error) { return &A{}, nil })
+	require.NoError(t, err)
+	_, err = r.RegisterGroup(func(name string) (*namedA, error) { return &namedA{name}, nil }, "first")
+	require.NoError(t, err)
+	_, err = r.RegisterGroup(func(name string) (*namedA, error) { return &namedA{name}, nil }, "second")
+	require.NoError(t, err)
+	_, err = r.Register(func(p *TargetParams) (*Target, error) { return &Target{p.All}, nil })
+	require.NoError(t, err)
+	require.NoError(t, r.Validate())
+
+	// group providers are only used for groups
+	_, err = r.Request(reflect.TypeOf(&A{}))
+	require.EqualError(t, err, "no provider for *registry.A")
+
+	target := &Target{}
+	require.NoError(t, r.RequestAndSet(&target))
+	require.Len(t, target.All, 3)
+	require.IsType(t, &A{}, target.All[0])
+	require.Equal(t, &namedA{"first"}, target.All[1])
+	require.Equal(t, &namedA{"second"}, target.All[2])
+	require.Len(t, r.Dependencies(target), 3)
+
+	t.Run("empty group", func(t *testing.T) {
+		r := New()
+		_, err := r.Register(func(p *TargetParams) (*Target, error) { return &Target{p.All}, nil })
+		require.NoError(t, err)
+		target := &Target{}
+		require.NoError(t, r.RequestAndSet(&target))
+		require.Empty(t, target.All)
+	})
+
+	t.Run("group field needs to be a slice", func(t *testing.T) {
+		type InvalidParams struct {
+			Params
+			A IA `registry:"group"`
+		}
+		_, err := New().Register(func(p *InvalidParams) (*Target, error) { return &Target{}, nil })
+		require.EqualError(t, err, "group field A of registry.InvalidParams needs to be a slice")
+	})
+}
+
+func TestLifetimes(t *testing.T) {
+	type Config struct{}
+	type Request struct{ Config *Config }
+	type Tenant struct {
+		Config *Config
+		Name   string
+	}
+	type Handler struct {
+		Tenant  *Tenant
+		Request *Request
+	}
+	typeOf := func(v interface{}) reflect.Type { return reflect.TypeOf(v) }
+
+	r := New()
+	_, err := r.Register(func() (*Config, error) { return &Config{}, nil })
+	require.NoError(t, err)
+	_, err = r.RegisterTransient(func(c *Config) (*Request, error) { return &Request{c}, nil })
+	require.NoError(t, err)
+	_, err = r.RegisterScoped(func(c *Config, name string) (*Tenant, error) { return &Tenant{c, name}, nil }, "default")
+	require.NoError(t, err)
+	_, err = r.RegisterTransient(func(t *Tenant, req *Request) (*Handler, error) { return &Handler{t, req}, nil })
+	require.NoError(t, err)
+
+	t.Run("transient", func(t *testing.T) {
+		r1, err := r.Request(typeOf(&Request{}))
+		require.NoError(t, err)
+		r2, err := r.Request(typeOf(&Request{}))
+		require.NoError(t, err)
+		require.False(t, r1 == r2)
+		require.True(t, r1.(*Request).Config == r2.(*Request).Config)
+	})
+
+	t.Run("scoped", func(t *testing.T) {
+		root, err := r.Request(typeOf(&Tenant{}))
+		require.NoError(t, err)
+		again, err := r.Request(typeOf(&Tenant{}))
+		require.NoError(t, err)
+		require.True(t, root == again)
+
+		s1, s2 := NewScope(r), NewScope(r)
+		t1, err := s1.Request(typeOf(&Tenant{}))
+		require.NoError(t, err)
+		t2, err := s2.Request(typeOf(&Tenant{}))
+		require.NoError(t, err)
+		require.False(t, t1 == root)
+		require.False(t, t1 == t2)
+		// singletons are shared
+		require.True(t, t1.(*Tenant).Config == root.(*Tenant).Config)
+
+		h, err := s1.Request(typeOf(&Handler{}))
+		require.NoError(t, err)
+		require.True(t, h.(*Handler).Tenant == t1)
+		require.Equal(t, []interface{}{root.(*Tenant).Config}, s1.Dependencies(t1))
+	})
+
+	t.Run("scope providers", func(t *testing.T) {
+		s := NewScope(r)
+		// providers of the scope take precedence and are not visible to the parent
+		_, err := s.RegisterScoped(func(c *Config) (*Tenant, error) { return &Tenant{c, "scope"}, nil })
+		require.NoError(t, err)
+		tenant, err := s.Request(typeOf(&Tenant{}))
+		require.NoError(t, err)
+		require.Equal(t, "scope", tenant.(*Tenant).Name)
+
+		type Local struct{}
+		_, err = s.Register(func() (*Local, error) { return &Local{}, nil })
+		require.NoError(t, err)
+		_, err = r.Request(typeOf(&Local{}))
+		require.EqualError(t, err, "no provider for *registry.Local")
+		require.NoError(t, s.Validate())
+	})
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	type Slow struct{}
+	type Dependent struct{ *Slow }
+
+	r := New()
+	var calls int32
+	_, err := r.Register(func() (*Slow, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		return &Slow{}, nil
+	})
+	require.NoError(t, err)
+	_, err = r.Register(func(s *Slow) (*Dependent, error) { return &Dependent{s}, nil })
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	results := make([]interface{}, 20)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			if i%2 == 0 {
+				results[i], err = r.Request(reflect.TypeOf(&Slow{}))
+			} else {
+				var d interface{}
+				d, err = r.Request(reflect.TypeOf(&Dependent{}))
+				results[i] = d.(*Dependent).Slow
+			}
+			assert.NoError(t, err)
+		}(i)
+	}
+	// registering and inspecting while requests are running is safe
+	for i := 0; i < 10; i++ {
+		type Other struct{ i int }
+		_, _ = r.RegisterGroup(func() (*Other, error) { return &Other{}, nil })
+		_ = r.Graph()
+	}
+	wg.Wait()
+
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	for _, res := range results {
+		require.True(t, res == results[0])
+	}
+}
+
+func TestConcurrentRequestsOfCycle(t *testing.T) {
+	type B struct{ a *A }
+	type SlowA struct{}
+	type SlowB struct{}
+	type AParams struct {
+		Params
+		Slow *SlowA
+		B    *B
+	}
+	type BParams struct {
+		Params
+		Slow *SlowB
+		A    *A
+	}
+
+	r := New()
+	// the slow dependencies keep A and B locked long enough for the requests to cross
+	_, err := r.Register(func() (*SlowA, error) { time.Sleep(10 * time.Millisecond); return &SlowA{}, nil })
+	require.NoError(t, err)
+	_, err = r.Register(func() (*SlowB, error) { time.Sleep(10 * time.Millisecond); return &SlowB{}, nil })
+	require.NoError(t, err)
+	_, err = r.Register(func(p *AParams) (*A, error) { return &A{}, nil })
+	require.NoError(t, err)
+	_, err = r.Register(func(p *BParams) (*B, error) { return &B{p.A}, nil })
+	require.NoError(t, err)
+
+	// requesting the cycle from both ends at the same time fails instead of dead locking
+	errs := make(chan error)
+	for _, typ := range []reflect.Type{reflect.TypeOf(&A{}), reflect.TypeOf(&B{})} {
+		go func(typ reflect.Type) {
+			_, err := r.Request(typ)
+			errs <- err
+		}(typ)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "dependency cycle")
+		case <-time.After(5 * time.Second):
+			t.Fatal("requests dead locked")
+		}
+	}
+}
+
+func TestCycleCheckAfterRegister(t *testing.T) {
+	type B struct{ a *A }
+	type AParams struct {
+		Params
+		B *B `registry:"optional"`
+	}
+
+	r := New()
+	_, err := r.RegisterTransient(func(p *AParams) (*A, error) { return &A{}, nil })
+	require.NoError(t, err)
+	_, err = r.Request(reflect.TypeOf(&A{}))
+	require.NoError(t, err)
+
+	// the cached check of A is outdated once B is registered
+	_, err = r.RegisterTransient(func(a *A) (*B, error) { return &B{a}, nil })
+	require.NoError(t, err)
+	_, err = r.Request(reflect.TypeOf(&A{}))
+	require.EqualError(t, err, "dependency cycle: *registry.A -> *registry.B -> *registry.A")
+}
+
+func TestOverrideAndDecorate(t *testing.T) {
+	type Client struct{ Name string }
+	type User struct{ *Client }
+	typeOf := func(v interface{}) reflect.Type { return reflect.TypeOf(v) }
+
+	r := New()
+	_, err := r.Register(func() (*Client, error) { return &Client{"real"}, nil })
+	require.NoError(t, err)
+	_, err = r.RegisterTransient(func(c *Client) (*User, error) { return &User{c}, nil })
+	require.NoError(t, err)
+
+	require.NoError(t, r.Decorate(func(c *Client) (*Client, error) {
+		return &Client{c.Name + "+decorated"}, nil
+	}))
+	_, err = r.Override(func() (*Client, error) { return &Client{"fake"}, nil })
+	require.NoError(t, err)
+
+	u, err := r.Request(typeOf(&User{}))
+	require.NoError(t, err)
+	require.Equal(t, "fake+decorated", u.(*User).Name)
+
+	_, err = r.Override(func() (*Client, error) { return &Client{"late"}, nil })
+	require.EqualError(t, err, "can not override *registry.Client, it was already instantiated")
+	err = r.Decorate(func(c *Client) (*Client, error) { return c, nil })
+	require.EqualError(t, err, "can not decorate *registry.Client, it was already instantiated")
+
+	t.Run("keeps lifetime", func(t *testing.T) {
+		_, err := r.Override(func(c *Client) (*User, error) { return &User{&Client{"override"}}, nil })
+		require.NoError(t, err)
+		u1, err := r.Request(typeOf(&User{}))
+		require.NoError(t, err)
+		u2, err := r.Request(typeOf(&User{}))
+		require.NoError(t, err)
+		require.False(t, u1 == u2)
+		require.Equal(t, "override", u1.(*User).Name)
+	})
+
+	t.Run("registers missing provider", func(t *testing.T) {
+		r := New()
+		_, err := r.Override(func() (*Client, error) { return &Client{"fake"}, nil })
+		require.NoError(t, err)
+		c, err := r.Request(typeOf(&Client{}))
+		require.NoError(t, err)
+		require.Equal(t, "fake", c.(*Client).Name)
+	})
+
+	t.Run("decorator errors", func(t *testing.T) {
+		r := New()
+		err := r.Decorate(func(c *Client) (*Client, error) { return c, nil })
+		require.EqualError(t, err, "no provider for *registry.Client to decorate")
+		err = r.Decorate(func(c *Client) (*User, error) { return nil, nil })
+		require.EqualError(t, err, "Decorate expects a function of the form func(T) (T, error) not func(*registry.Client) (*registry.User, error)")
+
+		_, err = r.Register(func() (*Client, error) { return &Client{}, nil })
+		require.NoError(t, err)
+		require.NoError(t, r.Decorate(func(c *Client) (*Client, error) { return nil, errors.New("failed") }))
+		_, err = r.Request(typeOf(&Client{}))
+		require.EqualError(t, err, "resolving *registry.Client: failed")
+
+		r = New()
+		_, err = r.Register(func() (*Client, error) { return &Client{}, nil })
+		require.NoError(t, err)
+		require.NoError(t, r.Decorate(func(c *Client) (*Client, error) { return nil, nil }))
+		_, err = r.Request(typeOf(&Client{}))
+		require.EqualError(t, err, "resolving *registry.Client: The decorator func(*registry.Client) (*registry.Client, error) return a nil value, this is not allowed")
+	})
+}
+
+func TestOptionalParams(t *testing.T) {
+	type Cache struct{}
+	type Config struct {
+		Params
+		Cache   *Cache        `registry:"optional"`
+		Backend IA            `registry:"optional"`
+		Hosts   []string      `registry:"optional"`
+		Admin   *A            `registry:"optional,name=admin"`
+		Timeout time.Duration `registry:"default=10s"`
+		Retries int           `registry:"default=3"`
+		Ratio   float64       `registry:"default=0.5"`
+		Enabled bool          `registry:"default=true"`
+		Name    string        `registry:"optional,default=a,b"`
+	}
+
+	r := New()
+	_, err := r.Register(func(p *Config) (*Config, error) { return p, nil })
+	require.NoError(t, err)
+	require.NoError(t, r.Validate())
+
+	var c *Config
+	require.NoError(t, r.RequestAndSet(&c))
+	require.Equal(t, &Config{Timeout: 10 * time.Second, Retries: 3, Ratio: 0.5, Enabled: true, Name: "a,b"}, c)
+
+	t.Run("providers take precedence", func(t *testing.T) {
+		r := New()
+		_, err := r.Register(func() (*Cache, error) { return &Cache{}, nil })
+		require.NoError(t, err)
+		_, err = r.Register(func() (*A, error) { return &A{}, nil })
+		require.NoError(t, err)
+		_, err = r.Register(func(p *Config) (*Config, error) { return p, nil })
+		require.NoError(t, err)
+
+		var c *Config
+		require.NoError(t, r.RequestAndSet(&c))
+		require.NotNil(t, c.Cache)
+		require.NotNil(t, c.Backend)
+		require.Nil(t, c.Admin)
+	})
+
+	t.Run("invalid default", func(t *testing.T) {
+		type Invalid struct {
+			Params
+			Retries int `registry:"default=many"`
+		}
+		_, err := New().Register(func(p *Invalid) (*Cache, error) { return &Cache{}, nil })
+		require.EqualError(t, err, `invalid default for field Retries of registry.Invalid: strconv.ParseInt: parsing "many": invalid syntax`)
+
+		type Unsupported struct {
+			Params
+			Cache *Cache `registry:"default=x"`
+		}
+		_, err = New().Register(func(p *Unsupported) (*Cache, error) { return &Cache{}, nil })
+		require.EqualError(t, err, "invalid default for field Cache of registry.Unsupported: default values are not supported for *registry.Cache")
+	})
+
+	t.Run("missing lazy param is an error", func(t *testing.T) {
+		type Lazy struct {
+			Params
+			Cache *Cache `registry:"lazy"`
+		}
+		r := New()
+		_, err := r.Register(func(p *Lazy) (*A, error) { return &A{}, nil })
+		require.NoError(t, err)
+		_, err = r.Request(reflect.TypeOf(&A{}))
+		require.EqualError(t, err, "resolving *registry.A: could not find struct param *registry.Cache for registry.Lazy")
+	})
+}
+
+func TestConstructions(t *testing.T) {
+	type Slow struct{}
+	type Root struct{}
+	type Broken struct{}
+	type Wrapper struct{}
+	type Missing struct{}
+	type Incomplete struct{}
+
+	r := New()
+	var traced []Construction
+	r.OnConstruction(func(c Construction) { traced = append(traced, c) })
+	_, err := r.Register(func() (*Slow, error) {
+		time.Sleep(10 * time.Millisecond)
+		return &Slow{}, nil
+	})
+	require.NoError(t, err)
+	_, err = r.Register(func(s *Slow) (*Root, error) { return &Root{}, nil })
+	require.NoError(t, err)
+	_, err = r.RegisterNamed("broken", func() (*Broken, error) { return nil, errors.New("failed") })
+	require.NoError(t, err)
+	_, err = r.Register(func(p *struct {
+		Params
+		Broken *Broken `registry:"name=broken"`
+	}) (*Wrapper, error) {
+		return &Wrapper{}, nil
+	})
+	require.NoError(t, err)
+	_, err = r.Register(func(m *Missing) (*Incomplete, error) { return &Incomplete{}, nil })
+	require.NoError(t, err)
+
+	_, err = r.Request(reflect.TypeOf(&Root{}))
+	require.NoError(t, err)
+	_, err = r.Request(reflect.TypeOf(&Wrapper{}))
+	require.EqualError(t, err, "resolving *registry.Wrapper -> *registry.Broken[broken]: failed")
+	_, err = r.Request(reflect.TypeOf(&Incomplete{}))
+	require.EqualError(t, err, "resolving *registry.Incomplete: no provider for *registry.Missing (and no exact signature match), "+
+		"required by func(*registry.Missing) (*registry.Incomplete, error)")
+
+	constructions := r.Constructions()
+	require.Len(t, constructions, 4)
+	require.Equal(t, "*registry.Slow", constructions[0].Provides)
+	require.Equal(t, []string{"*registry.Root"}, constructions[0].RequestedBy)
+	require.True(t, constructions[0].Took >= 10*time.Millisecond)
+	require.Equal(t, "*registry.Root", constructions[1].Provides)
+	require.Empty(t, constructions[1].RequestedBy)
+	require.Equal(t, Construction{
+		Provides:    "*registry.Broken",
+		Name:        "broken",
+		RequestedBy: []string{"*registry.Wrapper"},
+		Started:     constructions[2].Started,
+		Took:        constructions[2].Took,
+		Error:       "failed",
+	}, constructions[2])
+	// missing providers are recorded as failed constructions
+	require.Equal(t, "*registry.Missing", constructions[3].Provides)
+	require.Equal(t, []string{"*registry.Incomplete"}, constructions[3].RequestedBy)
+	require.Contains(t, constructions[3].Error, "no provider for *registry.Missing")
+	require.Equal(t, constructions, traced)
+
+	// functions registered later get the previous constructions
+	var late []Construction
+	r.OnConstruction(func(c Construction) { late = append(late, c) })
+	require.Equal(t, constructions, late)
+}
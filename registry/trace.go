@@ -0,0 +1,87 @@
+package registry
+
+import (
+	"time"
+)
+
+// maxConstructions limits the number of constructor calls kept by a registry. Transient providers
+// are called on every request, so only the first calls, usually the ones during startup, are kept.
+const maxConstructions = 1024
+
+// Construction describes a single constructor call. A requirement without a provider is recorded as
+// failed construction of the required type.
+type Construction struct {
+	// Provides is the type created by the constructor
+	Provides string
+	// Name is the name the provider was registered with
+	Name string `json:",omitempty"`
+	// RequestedBy is the resolution path that led to the call, starting with the requested
+	// provider and ending with the provider that required this one directly. It is empty if the
+	// provider was requested directly.
+	RequestedBy []string `json:",omitempty"`
+	Started     time.Time
+	// Took is the time spent in the constructor and its decorators, without the time needed to
+	// resolve the dependencies
+	Took  time.Duration
+	Error string `json:",omitempty"`
+}
+
+// Constructions returns the constructor calls of this registry in the order they finished. Only the
+// first 1024 calls are kept.
+func (r *Registry) Constructions() []Construction {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]Construction(nil), r.constructions...)
+}
+
+// OnConstruction registers a function that is called after every constructor call of this registry,
+// e.g. to record metrics. The function is called with the constructions recorded so far first.
+func (r *Registry) OnConstruction(fn func(Construction)) {
+	r.mu.Lock()
+	constructions := append([]Construction(nil), r.constructions...)
+	r.tracers = append(r.tracers, fn)
+	r.mu.Unlock()
+
+	for _, c := range constructions {
+		fn(c)
+	}
+}
+
+// trace records the call of the constructor of p, path is the resolution path ending with p
+func (r *Registry) trace(p *provider, path []*provider, started time.Time, err error) {
+	r.record(p.String(), Construction{
+		Provides: p.provides.String(),
+		Name:     p.name,
+		Started:  started,
+		Took:     time.Since(started),
+	}, path[:len(path)-1], err)
+}
+
+// missing records a requirement without a provider as failed construction and returns err with the
+// resolution path, path ends with the provider that required req
+func (r *Registry) missing(req requirement, path []*provider, err error) error {
+	r.record(req.String(), Construction{Provides: req.t.String(), Name: req.name, Started: time.Now()}, path, err)
+	return resolveError(path, err)
+}
+
+// record adds the construction of what to the constructions of r and notifies the tracers
+func (r *Registry) record(what string, c Construction, requestedBy []*provider, err error) {
+	for _, requester := range requestedBy {
+		c.RequestedBy = append(c.RequestedBy, requester.String())
+	}
+	if err != nil {
+		c.Error = err.Error()
+		r.log.Debugf("constructor of %s failed after %v, requested by %v: %v", what, c.Took, c.RequestedBy, err)
+	}
+
+	r.mu.Lock()
+	if len(r.constructions) < maxConstructions {
+		r.constructions = append(r.constructions, c)
+	}
+	tracers := r.tracers
+	r.mu.Unlock()
+
+	for _, fn := range tracers {
+		fn(c)
+	}
+}
@@ -0,0 +1,195 @@
+package registry
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+// Validate checks the graph of all registered providers without instantiating anything. It reports
+// dependency cycles with their full path, requirements without a provider and interface requirements
+// implemented by more than one provided type.
+// Lazy parameters can't be checked. Trailing params without a provider of constructors without a Params
+// struct are expected to be passed to Request or given on Register. Optional requirements don't need a
+// provider either.
+func (r *Registry) Validate() error {
+	var result error
+	edges := map[*provider][]*provider{}
+	for _, p := range r.sortedProviders() {
+		deps, err := r.requiredProviders(p)
+		if err != nil {
+			result = multierror.Append(result, err)
+		}
+		edges[p] = deps
+	}
+
+	for _, cycle := range findCycles(r.sortedProviders(), edges) {
+		result = multierror.Append(result, cycleError(cycle))
+	}
+	return result
+}
+
+// requiredProviders returns the providers p depends on
+func (r *Registry) requiredProviders(p *provider) ([]*provider, error) {
+	var result error
+	var deps []*provider
+	for _, dep := range r.dependencies(p) {
+		switch {
+		case dep.err != nil:
+			result = multierror.Append(result, fmt.Errorf("%v, required by %v", dep.err, p.ctor.Type()))
+		case len(dep.providers) == 0 && !dep.group && !dep.optional:
+			result = multierror.Append(result, fmt.Errorf("no provider for %v, required by %v", dep.requirement, p.ctor.Type()))
+		default:
+			deps = append(deps, dep.providers...)
+		}
+	}
+	return deps, result
+}
+
+// dependency is a requirement of a provider and the providers satisfying it. Groups are satisfied by all
+// their members, other requirements by a single provider.
+type dependency struct {
+	requirement
+	providers []*provider
+	err       error
+}
+
+// dependencies returns the requirements of p that are resolved when p is constructed, the same way as
+// resolve does
+func (r *Registry) dependencies(p *provider) []dependency {
+	var deps []dependency
+	for _, req := range p.requires[:r.requestArgsFrom(p)] {
+		dep := dependency{requirement: req}
+		if req.group {
+			dep.providers = r.groupProviders(req)
+		} else if found, err := r.lookup(req); err != nil {
+			dep.err = err
+		} else if found != nil {
+			dep.providers = []*provider{found}
+		}
+		deps = append(deps, dep)
+	}
+	return deps
+}
+
+// requestArgsFrom returns the index of the first param of the constructor of p that is expected to be
+// passed to Request or given on Register instead of being resolved. These are the trailing params without
+// a provider followed by the static args. Constructors taking a Params struct can't be passed params.
+func (r *Registry) requestArgsFrom(p *provider) int {
+	if p.expectedParamStruct != nil {
+		return len(p.requires)
+	}
+	t := p.ctor.Type()
+	from := t.NumIn() - len(p.staticArgs)
+	if !exactSubSignatureMatch(t, from, p.staticArgs) {
+		return len(p.requires)
+	}
+	for from > 0 {
+		if dep, err := r.lookup(p.requires[from-1]); dep != nil || err != nil {
+			break
+		}
+		from--
+	}
+	return from
+}
+
+// cycleCheck is the result of checkCycles for a provider at a registry version
+type cycleCheck struct {
+	version uint64
+	err     error
+}
+
+// checkCycles returns an error if a dependency cycle is reachable from p. resolve holds the lock of every
+// instance while constructing it, so requests entering a cycle from different providers at the same time
+// would dead lock before the cycle shows up in their resolution path. The result is cached until the
+// providers visible to r change.
+func (r *Registry) checkCycles(p *provider) error {
+	version := r.version()
+	if c, found := r.cycleChecks.Load(p); found && c.(cycleCheck).version == version {
+		return c.(cycleCheck).err
+	}
+
+	edges := map[*provider][]*provider{}
+	var walk func(s *Registry, p *provider)
+	walk = func(s *Registry, p *provider) {
+		if _, found := edges[p]; found {
+			return
+		}
+		if p.lifetime == Singleton {
+			s = p.owner
+		}
+		// missing providers are reported by resolve
+		deps, _ := s.requiredProviders(p)
+		edges[p] = deps
+		for _, dep := range deps {
+			walk(s, dep)
+		}
+	}
+	walk(r, p)
+
+	var err error
+	if cycles := findCycles([]*provider{p}, edges); len(cycles) > 0 {
+		err = cycleError(cycles[0])
+	}
+	r.cycleChecks.Store(p, cycleCheck{version, err})
+	return err
+}
+
+func (r *Registry) sortedProviders() []*provider {
+	providers := r.allProviders()
+	sort.Slice(providers, func(i, j int) bool {
+		return providers[i].String() < providers[j].String()
+	})
+	return providers
+}
+
+// findCycles does a depth first search and returns every cycle found as a path that starts and
+// ends with the same provider
+func findCycles(providers []*provider, edges map[*provider][]*provider) (cycles [][]*provider) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[*provider]int{}
+	var path []*provider
+
+	var visit func(p *provider)
+	visit = func(p *provider) {
+		state[p] = visiting
+		path = append(path, p)
+		for _, dep := range edges[p] {
+			switch state[dep] {
+			case unvisited:
+				visit(dep)
+			case visiting:
+				for i := len(path) - 1; i >= 0; i-- {
+					if path[i] == dep {
+						cycle := append([]*provider(nil), path[i:]...)
+						cycles = append(cycles, append(cycle, dep))
+						break
+					}
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[p] = visited
+	}
+
+	for _, p := range providers {
+		if state[p] == unvisited {
+			visit(p)
+		}
+	}
+	return cycles
+}
+
+func cycleError(cycle []*provider) error {
+	types := make([]string, len(cycle))
+	for i, p := range cycle {
+		types[i] = p.String()
+	}
+	return fmt.Errorf("dependency cycle: %s", strings.Join(types, " -> "))
+}
@@ -1,12 +1,16 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
 	"reflect"
 	rp "runtime/pprof"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -15,39 +19,128 @@ import (
 )
 
 // Runner runs services. Services that implement the Service interface can be added
-// using the Add method. On Run they are started in the order of adding and Runner waits
-// for a shutdown signal. The signal can come from the OS or Stop can be called. If such
-// a signal is received the services are shutdown in reverse order. A timeout for service
-// startup and shutdown can be configured using RunnerConfig. If a service doesn't terminate
-// in time, the whole process is kill with a KILL signal.
+// using the Add method. On Run they are started according to their dependencies and Runner
+// waits for a shutdown signal. Services without declared dependencies depend on every service
+// added before them, so they are started in the order of adding. Services added with the
+// DependsOn option are started as soon as all their dependencies are initialized, which allows
+// independent services to start in parallel. The signal can come from the OS or Stop can be
+// called. If such a signal is received the services are shutdown in reverse order of their
+// initialization. A SIGHUP doesn't shutdown the services but reloads every service implementing
+// Reloadable. A timeout for service startup and shutdown can be configured using RunnerConfig.
+// If a service doesn't terminate in time, the whole process is kill with a KILL signal.
 type Runner struct {
 	RunnerConfig
-	services []*runnable
-	signals  chan os.Signal
-	log      cue.Logger
+	services     []*runnable
+	signals      chan os.Signal
+	reloads      chan os.Signal
+	log          cue.Logger
+	healthChecks HealthCheckRegistry
+	state        ServiceState
+
+	// mu guards services while adding and lastReload
+	mu         sync.Mutex
+	lastReload *ReloadReport
 }
 
-// RunnerConfig allows to configure timeouts for a Runner and provides a way to register a
-// post shutdown callback.
+// RunnerConfig allows to configure timeouts for a Runner and provides a way to register post init
+// and post shutdown callbacks. PostInit is called once all services are initialized. InitTimeout, ShutdownTimeout and OnInitSignalTimeout apply to every
+// single service. InitTimeout and ShutdownTimeout are defaults that can be overridden per service
+// (see WithInitTimeout, WithShutdownTimeout, InitBudgeter and ShutdownBudgeter).
+// If LameDuckPeriod is set, the runner waits that long after receiving a signal before it shuts down services.
+// A service exceeding its shutdown budget gets ShutdownOverrunGrace to finish, so the timeout error can
+// report how long the shutdown actually took.
+// If ParallelInit is set, RunnerWithRegistry derives the dependencies of created services from
+// the registry so services that don't depend on each other are initialized in parallel. It is off by
+// default, also for runners created by Cmd, and needs to be set before the services are created.
 type RunnerConfig struct {
-	ShutdownTimeout     time.Duration
-	InitTimeout         time.Duration
-	OnInitSignalTimeout time.Duration
-	PostShutdown        func(error)
+	ShutdownTimeout      time.Duration
+	InitTimeout          time.Duration
+	OnInitSignalTimeout  time.Duration
+	LameDuckPeriod       time.Duration
+	ShutdownOverrunGrace time.Duration
+	ParallelInit         bool
+	PostInit             func()
+	PostShutdown         func(error)
 }
 
 type runnable struct {
-	Service
+	ContextService
+	// svc is the value added to the runner. It identifies the service and is used to
+	// detect optional interfaces like HealthCheckable.
+	svc  interface{}
 	name string
+
+	// dependsOn holds the services that need to be initialized before this one. If
+	// explicitDeps is false the runnable depends on all services added before it.
+	dependsOn         []interface{}
+	explicitDeps      bool
+	ignoreUnknownDeps bool
+	deps              []*runnable
+
+	initTimeout     time.Duration
+	shutdownTimeout time.Duration
+
+	state ServiceState
+
+	initStarted time.Time
+	initDone    time.Time
+}
+
+// ServiceOption configures how a service is run by a Runner
+type ServiceOption func(*runnable)
+
+// DependsOn declares the services that need to be initialized before the added service
+// is initialized. Calling it without any services marks the service as independent so it
+// is started right away. All dependencies need to be added to the runner before Run is called,
+// either as Service or as ContextService.
+func DependsOn(services ...interface{}) ServiceOption {
+	return func(s *runnable) {
+		s.dependsOn = append(s.dependsOn, services...)
+		s.explicitDeps = true
+	}
+}
+
+// WithInitTimeout sets the init budget of the added service. It takes precedence over
+// InitBudgeter and RunnerConfig.InitTimeout.
+func WithInitTimeout(d time.Duration) ServiceOption {
+	return func(s *runnable) {
+		s.initTimeout = d
+	}
+}
+
+// WithShutdownTimeout sets the shutdown budget of the added service. It takes precedence over
+// ShutdownBudgeter and RunnerConfig.ShutdownTimeout.
+func WithShutdownTimeout(d time.Duration) ServiceOption {
+	return func(s *runnable) {
+		s.shutdownTimeout = d
+	}
+}
+
+// WithName sets the name of the added service used in logs and health checks. By default services
+// are named after their type.
+func WithName(name string) ServiceOption {
+	return func(s *runnable) {
+		s.name = name
+	}
+}
+
+// dependsOnIfAdded works like DependsOn but ignores dependencies that were never added
+// to the runner. It is used for dependencies derived from the registry graph.
+func dependsOnIfAdded(services ...interface{}) ServiceOption {
+	return func(s *runnable) {
+		DependsOn(services...)(s)
+		s.ignoreUnknownDeps = true
+	}
 }
 
 // NewRunnerDefaultConfig create a default RunnerConfig
 func NewRunnerDefaultConfig() RunnerConfig {
 	return RunnerConfig{
-		InitTimeout:         time.Minute,
-		ShutdownTimeout:     time.Minute,
-		OnInitSignalTimeout: 10 * time.Second,
-		PostShutdown:        defaultPostShutdown,
+		InitTimeout:          time.Minute,
+		ShutdownTimeout:      time.Minute,
+		OnInitSignalTimeout:  10 * time.Second,
+		ShutdownOverrunGrace: 5 * time.Second,
+		PostShutdown:         defaultPostShutdown,
 	}
 }
 
@@ -60,6 +153,7 @@ func NewRunner() *Runner {
 func NewRunnerWithConfig(c RunnerConfig) *Runner {
 	r := &Runner{
 		signals:      make(chan os.Signal, 2), // this is buffered as the signal.Notify is using a non blocking send
+		reloads:      make(chan os.Signal, 1),
 		log:          NewLogger("runner"),
 		RunnerConfig: c,
 	}
@@ -67,25 +161,79 @@ func NewRunnerWithConfig(c RunnerConfig) *Runner {
 	return r
 }
 
-// Add adds a service that should be run by the runner. The order in which services are added determines the start and shutdown order.
-func (r *Runner) Add(s Service) {
-	t := reflect.TypeOf(s)
+// Add adds a service that should be run by the runner. Without options the order in which services
+// are added determines the start and shutdown order. Use DependsOn to declare the dependencies of
+// a service explicitly so it can be started in parallel to services it doesn't depend on.
+// Adding a service that was added before does nothing.
+func (r *Runner) Add(s Service, opts ...ServiceOption) {
+	r.add(s, serviceAdapter{s}, opts)
+}
+
+// AddContextService adds a service with a context aware lifecycle that should be run by the runner.
+// It behaves like Add.
+func (r *Runner) AddContextService(s ContextService, opts ...ServiceOption) {
+	r.add(s, s, opts)
+}
+
+func (r *Runner) add(svc interface{}, s ContextService, opts []ServiceOption) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.added(svc) {
+		r.log.Debugf("service %s was already added", serviceName(svc))
+		return
+	}
+	rs := &runnable{ContextService: s, svc: svc, name: serviceName(svc)}
+	for _, opt := range opts {
+		opt(rs)
+	}
+	r.services = append(r.services, rs)
+}
+
+func (r *Runner) added(svc interface{}) bool {
+	for _, s := range r.services {
+		// services of uncomparable types are never the same
+		if reflect.TypeOf(svc).Comparable() && s.svc == svc {
+			return true
+		}
+	}
+	return false
+}
+
+// serviceName returns the default name of a service, its type name
+func serviceName(svc interface{}) string {
+	t := reflect.TypeOf(svc)
 	if t.Kind() == reflect.Interface {
 		t = t.Elem()
 	}
 	if t.Kind() == reflect.Ptr {
 		t = t.Elem()
 	}
-	r.services = append(r.services, &runnable{Service: s, name: t.String()})
+	return t.String()
+}
+
+// RunnerHealthCheckName is the name of the health check that reports the state of the Runner itself
+const RunnerHealthCheckName = "runner"
+
+// UseHealthChecks makes the runner register a health check for every added service with h when Run is
+// called. The checks are named like the services and fail as long as a service is not initialized. Services
+// implementing HealthCheckable are checked using their Healthy method once they are initialized.
+// Additionally a check named RunnerHealthCheckName is registered that fails until all services are
+// initialized and as soon as the runner enters its lame duck period or begins to shutdown.
+func (r *Runner) UseHealthChecks(h HealthCheckRegistry) {
+	r.healthChecks = h
 }
 
-// Run initializes all services added to this runner in the order  of adding. If a termination signal is received all services are
-// shutdown in reverse order. If there was an error during initialization Run return this error early
+// Run initializes all services added to this runner. A service is initialized as soon as all services it depends on
+// are initialized, services without declared dependencies are initialized in the order of adding. If a termination
+// signal is received all services are shutdown in reverse order of their initialization. If there was an error during
+// initialization Run return this error early
 func (r *Runner) Run() (err error) {
 	var sig os.Signal
 	var inited []*runnable
 
+	r.setState(StateInitializing)
 	defer func() {
+		r.setState(StateShuttingDown)
 		var shutdownErr error
 		if len(inited) > 0 {
 			reversed := reverseServices(inited)
@@ -103,10 +251,15 @@ func (r *Runner) Run() (err error) {
 		if err == nil {
 			err = shutdownErr
 		}
-
+		r.setState(StateShutdown)
 	}()
 
-	r.log.Infof("starting services in order: %s", joinedServiceNames(r.services))
+	if err = r.resolveDependencies(); err != nil {
+		return errors.Wrap(err, "error during startup")
+	}
+	r.registerHealthChecks()
+
+	r.log.Infof("starting services: %s", joinedServiceNames(r.services))
 	inited, sig, err = r.initServices()
 	r.log.Infof("service start result err=%v signal=%v started=%s", err, sig, joinedServiceNames(inited))
 
@@ -117,101 +270,447 @@ func (r *Runner) Run() (err error) {
 	}
 
 	if sig == nil {
-		sig = <-r.signals
+		r.setState(StateInitialized)
+		if r.PostInit != nil {
+			r.PostInit()
+		}
+		sig = r.waitForSignal(inited)
 		r.log.Infof("signaled: %s", sig.String())
+		r.lameDuck(inited)
 	}
 
 	return err
 }
 
+// lameDuck keeps all services running for LameDuckPeriod while the runner health check fails, so load balancers
+// can drain traffic before services are shutdown. Services implementing Drainable are notified when the period
+// begins. Another signal ends the period early.
+func (r *Runner) lameDuck(services []*runnable) {
+	if r.LameDuckPeriod <= 0 {
+		return
+	}
+	r.setState(StateDraining)
+	r.log.Infof("entering lame duck period of %v", r.LameDuckPeriod)
+	for _, s := range services {
+		if d, ok := s.svc.(Drainable); ok {
+			d.Drain()
+		}
+	}
+
+	timer := time.NewTimer(r.LameDuckPeriod)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		r.log.Info("lame duck period is over")
+	case sig := <-r.signals:
+		r.log.Infof("signaled: %s, ending lame duck period early", sig.String())
+	}
+}
+
 // Stop signales this runner to initiate the shutdown process.
 func (r *Runner) Stop() {
 	r.signals <- syscall.SIGQUIT
 }
 
+// Reload signals this runner to reload all services implementing Reloadable, like a SIGHUP does.
+// If a reload is already pending this is a no-op.
+func (r *Runner) Reload() {
+	select {
+	case r.reloads <- syscall.SIGHUP:
+	default:
+	}
+}
+
 func (r *Runner) setupSignals() {
 	signal.Notify(r.signals,
-		syscall.SIGHUP,
 		syscall.SIGINT,
 		syscall.SIGQUIT,
 		syscall.SIGTERM,
 	)
+	signal.Notify(r.reloads, syscall.SIGHUP)
 }
 
+// waitForSignal blocks until a termination signal is received. Reload signals received in the meantime
+// trigger a reload of the given services.
+func (r *Runner) waitForSignal(services []*runnable) os.Signal {
+	for {
+		select {
+		case sig := <-r.signals:
+			return sig
+		case sig := <-r.reloads:
+			r.log.Infof("signaled: %s, reloading services", sig.String())
+			r.reload(services)
+		}
+	}
+}
+
+// ReloadResult is the outcome of reloading a single service
+type ReloadResult struct {
+	Service string
+	Took    time.Duration
+	Error   string `json:",omitempty"`
+}
+
+// ReloadReport holds the results of the reload of all Reloadable services
+type ReloadReport struct {
+	At      time.Time
+	Results []ReloadResult
+}
+
+// LastReload returns the report of the last reload or nil if there was none
+func (r *Runner) LastReload() *ReloadReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastReload
+}
+
+// reload calls Reload on all services implementing Reloadable in the given order. A failing reload
+// doesn't stop the reload of the remaining services.
+func (r *Runner) reload(services []*runnable) {
+	report := &ReloadReport{At: time.Now()}
+	for _, s := range services {
+		rl, ok := s.svc.(Reloadable)
+		if !ok {
+			continue
+		}
+		t := time.Now()
+		err := rl.Reload()
+		res := ReloadResult{Service: s.name, Took: time.Since(t)}
+		log := r.log.WithFields(cue.Fields{"service": s.name, "took": res.Took})
+		if err != nil {
+			res.Error = err.Error()
+			log.Warnf("reload failed, keeping previous configuration: %v", err)
+		} else {
+			log.Info("reload successful")
+		}
+		report.Results = append(report.Results, res)
+	}
+
+	r.mu.Lock()
+	r.lastReload = report
+	r.mu.Unlock()
+}
+
+// resolveDependencies maps the declared dependencies of every runnable to the runnables added to
+// this runner and makes sure the resulting graph is free of cycles.
+func (r *Runner) resolveDependencies() error {
+	index := make(map[interface{}]*runnable, len(r.services))
+	for _, s := range r.services {
+		if !reflect.TypeOf(s.svc).Comparable() {
+			continue
+		}
+		if _, found := index[s.svc]; !found {
+			index[s.svc] = s
+		}
+	}
+
+	for i, s := range r.services {
+		if !s.explicitDeps {
+			s.deps = append([]*runnable(nil), r.services[:i]...)
+			continue
+		}
+		s.deps = nil
+		for _, dep := range s.dependsOn {
+			var d *runnable
+			if dep != nil && reflect.TypeOf(dep).Comparable() {
+				d = index[dep]
+			}
+			if d == nil {
+				if s.ignoreUnknownDeps {
+					continue
+				}
+				return fmt.Errorf("service %s depends on %T which was not added to the runner", s.name, dep)
+			}
+			if d == s {
+				return fmt.Errorf("service %s depends on itself", s.name)
+			}
+			s.deps = append(s.deps, d)
+		}
+	}
+
+	// Kahn's algorithm - every service that can't be visited is part of a cycle
+	pending := make(map[*runnable]int, len(r.services))
+	dependents := make(map[*runnable][]*runnable, len(r.services))
+	var ready []*runnable
+	for _, s := range r.services {
+		pending[s] = len(s.deps)
+		for _, d := range s.deps {
+			dependents[d] = append(dependents[d], s)
+		}
+		if len(s.deps) == 0 {
+			ready = append(ready, s)
+		}
+	}
+	visited := 0
+	for len(ready) > 0 {
+		s := ready[0]
+		ready = ready[1:]
+		visited++
+		for _, d := range dependents[s] {
+			pending[d]--
+			if pending[d] == 0 {
+				ready = append(ready, d)
+			}
+		}
+	}
+	if visited != len(r.services) {
+		var cyclic []*runnable
+		for _, s := range r.services {
+			if pending[s] > 0 {
+				cyclic = append(cyclic, s)
+			}
+		}
+		return fmt.Errorf("dependency cycle between services: %s", joinedServiceNames(cyclic))
+	}
+	return nil
+}
+
+func (r *Runner) registerHealthChecks() {
+	if r.healthChecks == nil {
+		return
+	}
+	r.healthChecks.AddCheck(RunnerHealthCheckName, runnerHealth{r})
+	for _, s := range r.services {
+		r.healthChecks.AddCheck(s.name, s)
+	}
+}
+
+func (r *Runner) setState(state ServiceState) {
+	atomic.StoreInt32((*int32)(&r.state), int32(state))
+}
+
+// LifecycleState returns the state of the runner. It is StateInitialized once all services are initialized
+// and StateShuttingDown as soon as the runner begins to shutdown services.
+func (r *Runner) LifecycleState() ServiceState {
+	return ServiceState(atomic.LoadInt32((*int32)(&r.state)))
+}
+
+// runnerHealth is the health check for the runner itself
+type runnerHealth struct {
+	r *Runner
+}
+
+func (h runnerHealth) LifecycleState() ServiceState {
+	return h.r.LifecycleState()
+}
+
+func (h runnerHealth) Healthy() error {
+	if state := h.r.LifecycleState(); state != StateInitialized {
+		return fmt.Errorf("runner is %s", state)
+	}
+	return nil
+}
+
+// initResult is the outcome of a single service init
+type initResult struct {
+	s   *runnable
+	err error
+}
+
+// initServices initializes all services respecting their dependencies. Every service is started in its own
+// go routine as soon as all its dependencies are initialized and has InitTimeout to finish. If a service fails,
+// times out or a signal is received no further services are started and services that are still initializing
+// get OnInitSignalTimeout to finish. The returned services are in order of completed initialization.
+// The context passed to Start has a deadline of the service's init budget and is cancelled on a signal or
+// as soon as another service fails.
 func (r *Runner) initServices() ([]*runnable, os.Signal, error) {
 	var inited []*runnable
 
-	timer := time.NewTimer(r.InitTimeout)
-	defer timer.Stop()
-	c := make(chan error)
+	initCtx, cancelInit := context.WithCancel(context.Background())
+	defer cancelInit()
+
+	pending := make(map[*runnable]int, len(r.services))
+	dependents := make(map[*runnable][]*runnable, len(r.services))
 	for _, s := range r.services {
-		t := time.Now()
-		go func(s *runnable) {
-			r.log.WithValue("service", s.name).Info("service begin init")
-			c <- s.Init()
-		}(s)
+		pending[s] = len(s.deps)
+		for _, d := range s.deps {
+			dependents[d] = append(dependents[d], s)
+		}
+	}
+
+	results := make(chan initResult, len(r.services))
+	timeouts := make(chan *runnable, len(r.services))
+	running := make(map[*runnable]*time.Timer)
+
+	start := time.Now()
+	defer func() {
+		r.log.Info("startup waterfall")
+		for _, line := range formatWaterfall(start, r.services) {
+			r.log.Info(line)
+		}
+	}()
+
+	launch := func(s *runnable) {
+		s.initStarted = time.Now()
+		s.setState(StateInitializing)
+		r.log.WithFields(cue.Fields{
+			"service":    s.name,
+			"offset":     s.initStarted.Sub(start),
+			"depends_on": joinedServiceNames(s.deps),
+		}).Info("service begin init")
+		budget := s.initBudget(r.InitTimeout)
+		running[s] = time.AfterFunc(budget, func() { timeouts <- s })
+		ctx, cancel := context.WithTimeout(initCtx, budget)
+		go func(started time.Time) {
+			err := s.Start(ctx)
+			cancel()
+			if took := time.Since(started); took > budget {
+				r.log.WithFields(cue.Fields{"service": s.name, "budget": budget, "took": took}).
+					Warnf("service init exceeded its budget by %v", took-budget)
+			}
+			results <- initResult{s: s, err: err}
+		}(s.initStarted)
+	}
+
+	for _, s := range r.services {
+		if pending[s] == 0 {
+			launch(s)
+		}
+	}
+
+	for len(running) > 0 {
 		select {
-		case err := <-c:
-			if err != nil {
-				return inited, nil, errors.Wrapf(err, "service init failed for %s", s.name)
+		case res := <-results:
+			running[res.s].Stop()
+			delete(running, res.s)
+			res.s.initDone = time.Now()
+			if res.err != nil {
+				res.s.setState(StateInitFailed)
+				cancelInit()
+				inited, _ = r.awaitInit(running, results, inited, true)
+				return inited, nil, errors.Wrapf(res.err, "service init failed for %s", res.s.name)
 			}
-			r.log.WithFields(cue.Fields{"service": s.name, "took": time.Now().Sub(t)}).Info("service init successful")
-			inited = append(inited, s)
-		case sig := <-r.signals:
-			r.log.Infof("signaled: %s, waiting %v for %s to finish init before termination", sig.String(), r.OnInitSignalTimeout, s.name)
-			extraTime := time.NewTimer(r.OnInitSignalTimeout)
-			var err error
-			select {
-			case err = <-c:
-				if err == nil {
-					inited = append(inited, s)
+			r.log.WithFields(cue.Fields{"service": res.s.name, "took": res.s.initDone.Sub(res.s.initStarted)}).Info("service init successful")
+			res.s.setState(StateInitialized)
+			inited = append(inited, res.s)
+			for _, d := range dependents[res.s] {
+				pending[d]--
+				if pending[d] == 0 {
+					launch(d)
 				}
-			case <-extraTime.C:
-				r.log.Infof("signaled: %s, waiting for %s to finish init timed out, ignoring", sig.String(), s.name)
 			}
+		case s := <-timeouts:
+			if _, found := running[s]; !found {
+				// init finished right before the timer fired
+				continue
+			}
+			// the service stays in running, so it gets OnInitSignalTimeout to finish like all other
+			// services and the error can tell by how much it exceeded its budget
+			cancelInit()
+			inited, _ = r.awaitInit(running, results, inited, true)
+			err := newTimeoutError("timeout on service init", s.name, s.initBudget(r.InitTimeout), s.initStarted, s.initDone).logTo(r.log)
+			return inited, nil, err
+		case sig := <-r.signals:
+			r.log.Infof("signaled: %s, waiting %v for %s to finish init before termination", sig.String(), r.OnInitSignalTimeout, joinedServiceNames(runningServices(running)))
+			cancelInit()
+			var err error
+			inited, err = r.awaitInit(running, results, inited, true)
 			return inited, sig, err
-		case <-timer.C:
-			return inited, nil, newTimeoutError("timeout on service init", s.name, r.InitTimeout)
 		}
 	}
 	return inited, nil, nil
 }
 
+// awaitInit waits up to OnInitSignalTimeout for all running services to finish their init. Services that
+// finish successfully are appended to inited, the first init error is returned. If the init was cancelled
+// because of a signal or a failed service, services returning context.Canceled were aborted and did not fail.
+func (r *Runner) awaitInit(running map[*runnable]*time.Timer, results <-chan initResult, inited []*runnable, cancelled bool) ([]*runnable, error) {
+	for _, t := range running {
+		t.Stop()
+	}
+	if len(running) == 0 {
+		return inited, nil
+	}
+
+	extraTime := time.NewTimer(r.OnInitSignalTimeout)
+	defer extraTime.Stop()
+
+	var err error
+	for len(running) > 0 {
+		select {
+		case res := <-results:
+			delete(running, res.s)
+			res.s.initDone = time.Now()
+			if res.err == nil {
+				res.s.setState(StateInitialized)
+				inited = append(inited, res.s)
+				continue
+			}
+			if cancelled && errors.Is(res.err, context.Canceled) {
+				r.log.WithValue("service", res.s.name).Info("service init aborted")
+				res.s.setState(StateShutdown)
+				continue
+			}
+			res.s.setState(StateInitFailed)
+			if err == nil {
+				err = errors.Wrapf(res.err, "service init failed for %s", res.s.name)
+			}
+		case <-extraTime.C:
+			r.log.Infof("waiting for %s to finish init timed out, ignoring", joinedServiceNames(runningServices(running)))
+			return inited, err
+		}
+	}
+	return inited, err
+}
+
 // shutdownServices tries to shutdown every service/runnable owned by this runner in reverse order of initialization.
-// It passes the given signal to the Shutdown method of the runnable. If the accumulated time it takes to shutdown
-// all services is larger than the ShutdownTimeout, the shutdown is stopped and this methods returns a timeout error (as in timeout happend). Otherwise  nil is returned
+// It passes the given signal to the Shutdown method of the runnable (or as part of the context to Stop). Every service has its own shutdown budget
+// (see shutdownBudget). If a service takes longer than its budget, the shutdown is stopped and this methods returns
+// a timeout error (as in timeout happend) naming the service. Otherwise  nil is returned
 func (r *Runner) shutdownServices(services []*runnable, sig os.Signal) error {
-	timer := time.NewTimer(r.ShutdownTimeout)
-	defer timer.Stop()
-
-	c := make(chan struct{})
+	c := make(chan struct{}, 1)
 	for _, shuttingDown := range services {
-		r.log.WithValue("service", shuttingDown.name).Info("shutting down")
+		budget := shuttingDown.shutdownBudget(r.ShutdownTimeout)
+		r.log.WithFields(cue.Fields{"service": shuttingDown.name, "budget": budget}).Info("shutting down")
 
-		shutdownStarted := make(chan struct{})
+		t := time.Now()
+		shuttingDown.setState(StateShuttingDown)
+		ctx, cancel := context.WithTimeout(withSignal(context.Background(), sig), budget)
 		go func(s *runnable) {
-			shutdownStarted <- struct{}{}
-			t := time.Now()
+			defer cancel()
 			ticker := time.NewTicker(time.Second)
-			go r.watchShutdown(ticker, shuttingDown)
-			s.Shutdown(sig)
+			go r.watchShutdown(ticker, s)
+			if err := s.Stop(ctx); err != nil {
+				_ = r.log.WithValue("service", s.name).Error(err, "shutdown failed")
+			}
 			ticker.Stop()
-			r.log.WithFields(cue.Fields{"service": s.name, "took": time.Now().Sub(t)}).Info("shutdown done")
+			s.setState(StateShutdown)
+			took := time.Now().Sub(t)
+			r.log.WithFields(cue.Fields{"service": s.name, "took": took}).Info("shutdown done")
+			if took > budget {
+				r.log.WithFields(cue.Fields{"service": s.name, "budget": budget, "took": took}).
+					Warnf("service shutdown exceeded its budget by %v", took-budget)
+			}
 			c <- struct{}{}
 		}(shuttingDown)
 
-		<-shutdownStarted
+		timer := time.NewTimer(budget)
 		select {
 		case <-c: // nothing needs to be done
+			timer.Stop()
 		case <-timer.C:
-			err := newTimeoutError("timeout on service shutdown", shuttingDown.name, r.ShutdownTimeout).logTo(r.log)
-			shuttingDown = nil
-			return err
+			return newTimeoutError("timeout on service shutdown", shuttingDown.name, budget, t, r.awaitShutdown(c, t)).logTo(r.log)
 		}
 	}
 	return nil
 }
 
+// awaitShutdown waits up to ShutdownOverrunGrace for a service that exceeded its shutdown budget.
+// It returns the time the shutdown finished or the zero time if the service is still running.
+func (r *Runner) awaitShutdown(c <-chan struct{}, started time.Time) time.Time {
+	if r.ShutdownOverrunGrace <= 0 {
+		return time.Time{}
+	}
+	grace := time.NewTimer(r.ShutdownOverrunGrace)
+	defer grace.Stop()
+	select {
+	case <-c:
+		return time.Now()
+	case <-grace.C:
+		return time.Time{}
+	}
+}
+
 // defaultPostShutdown kills the current process the parameter timeout is true
 func defaultPostShutdown(err error) {
 	if isTimeoutError(err) {
@@ -235,24 +734,62 @@ func (r *Runner) watchShutdown(ticker *time.Ticker, s *runnable) {
 	}
 }
 
-// timeoutError is used to indicated that service init or  shutdown failed
+// initBudget returns the time the service may take to initialize
+func (s *runnable) initBudget(defaultBudget time.Duration) time.Duration {
+	if s.initTimeout > 0 {
+		return s.initTimeout
+	}
+	if b, ok := s.svc.(InitBudgeter); ok && b.InitBudget() > 0 {
+		return b.InitBudget()
+	}
+	return defaultBudget
+}
+
+// shutdownBudget returns the time the service may take to shutdown
+func (s *runnable) shutdownBudget(defaultBudget time.Duration) time.Duration {
+	if s.shutdownTimeout > 0 {
+		return s.shutdownTimeout
+	}
+	if b, ok := s.svc.(ShutdownBudgeter); ok && b.ShutdownBudget() > 0 {
+		return b.ShutdownBudget()
+	}
+	return defaultBudget
+}
+
+// timeoutError is used to indicated that service init or  shutdown failed. It names the service
+// that ran out of its budget and by how much the budget was exceeded. If the service was still running
+// when the error was created the overrun is a lower bound.
 // only used internally
 type timeoutError struct {
 	msg     string
 	service string
-	timeout time.Duration
+	budget  time.Duration
+	elapsed time.Duration
+	running bool
 }
 
-func newTimeoutError(msg, service string, timeout time.Duration) *timeoutError {
-	return &timeoutError{msg: msg, service: service, timeout: timeout}
+// newTimeoutError creates a timeoutError for a service that started at started and finished at done,
+// which is the zero time if the service is still running
+func newTimeoutError(msg, service string, budget time.Duration, started, done time.Time) *timeoutError {
+	e := &timeoutError{msg: msg, service: service, budget: budget, running: done.IsZero()}
+	if e.running {
+		done = time.Now()
+	}
+	e.elapsed = done.Sub(started)
+	return e
 }
 
 func (e *timeoutError) Error() string {
-	return fmt.Sprintf("%s service=%s after=%v", e.msg, e.service, e.timeout)
+	if e.running {
+		return fmt.Sprintf("%s service=%s budget=%v elapsed=%v, still running after exceeding its budget by %v",
+			e.msg, e.service, e.budget, e.elapsed, e.elapsed-e.budget)
+	}
+	return fmt.Sprintf("%s service=%s budget=%v elapsed=%v, exceeded its budget by %v",
+		e.msg, e.service, e.budget, e.elapsed, e.elapsed-e.budget)
 }
 
 func (e *timeoutError) logTo(log cue.Logger) error {
-	log.WithFields(cue.Fields{"service": e.service, "timeout": e.timeout}).Warn(e.msg)
+	log.WithFields(cue.Fields{"service": e.service, "budget": e.budget, "elapsed": e.elapsed, "running": e.running}).Warn(e.msg)
 	return e
 }
 
@@ -279,6 +816,74 @@ func joinedServiceNames(services []*runnable) string {
 	return strings.Join(names, ",")
 }
 
+func runningServices(running map[*runnable]*time.Timer) []*runnable {
+	var services []*runnable
+	for s := range running {
+		services = append(services, s)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].initStarted.Before(services[j].initStarted)
+	})
+	return services
+}
+
+// formatWaterfall renders the init timings of the given services relative to start as a waterfall chart
+// with one line per service
+func formatWaterfall(start time.Time, services []*runnable) []string {
+	const width = 40
+
+	var started []*runnable
+	var total time.Duration
+	nameWidth := 0
+	for _, s := range services {
+		if s.initStarted.IsZero() {
+			continue
+		}
+		started = append(started, s)
+		end := s.initDone
+		if end.IsZero() {
+			end = time.Now()
+		}
+		if d := end.Sub(start); d > total {
+			total = d
+		}
+		if len(s.name) > nameWidth {
+			nameWidth = len(s.name)
+		}
+	}
+	sort.SliceStable(started, func(i, j int) bool {
+		return started[i].initStarted.Before(started[j].initStarted)
+	})
+	if total <= 0 {
+		total = 1
+	}
+
+	var lines []string
+	for _, s := range started {
+		end, took := s.initDone, s.initDone.Sub(s.initStarted).String()
+		if end.IsZero() {
+			end, took = time.Now(), "unfinished"
+		}
+		from := int(int64(width) * int64(s.initStarted.Sub(start)) / int64(total))
+		to := int(int64(width) * int64(end.Sub(start)) / int64(total))
+		if to <= from {
+			to = from + 1
+		}
+		if to > width {
+			to = width
+			if from >= to {
+				from = to - 1
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%-*s |%s%s%s| +%v %s",
+			nameWidth, s.name,
+			strings.Repeat(" ", from), strings.Repeat("=", to-from), strings.Repeat(" ", width-to),
+			s.initStarted.Sub(start), took,
+		))
+	}
+	return lines
+}
+
 func reverseServices(s []*runnable) []*runnable {
 	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
 		s[i], s[j] = s[j], s[i]
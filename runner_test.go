@@ -1,30 +1,113 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"os"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
+
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/remerge/go-service/registry"
 )
 
+// testService records calls of its lifecycle methods. They are called from go routines of the runner,
+// so tests read the results using didInit and didShutdown and wait for calls using the channels
+// returned by events.
 type testService struct {
-	initRun         bool
-	shutdownRun     bool
 	sleepOnInit     time.Duration
 	sleepOnShutdown time.Duration
 	errOnInit       error
+	// blockInit and blockShutdown make Init and Shutdown wait until they are closed
+	blockInit     chan struct{}
+	blockShutdown chan struct{}
+
+	mu          sync.Mutex
+	initRun     bool
+	shutdownRun bool
+
+	once         sync.Once
+	initStarted  chan struct{}
+	initReturned chan struct{}
+}
+
+func (s *testService) events() (initStarted, initReturned <-chan struct{}) {
+	s.once.Do(func() {
+		s.initStarted = make(chan struct{})
+		s.initReturned = make(chan struct{})
+	})
+	return s.initStarted, s.initReturned
 }
 
 func (s *testService) Init() error {
+	s.events()
+	close(s.initStarted)
+	if s.blockInit != nil {
+		<-s.blockInit
+	}
 	time.Sleep(s.sleepOnInit)
+	s.mu.Lock()
 	s.initRun = true
+	s.mu.Unlock()
+	close(s.initReturned)
 	return s.errOnInit
 }
+
 func (s *testService) Shutdown(os.Signal) {
+	if s.blockShutdown != nil {
+		<-s.blockShutdown
+	}
 	time.Sleep(s.sleepOnShutdown)
+	s.mu.Lock()
 	s.shutdownRun = true
+	s.mu.Unlock()
+}
+
+func (s *testService) didInit() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.initRun
+}
+
+func (s *testService) didShutdown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shutdownRun
+}
+
+// awaitEvent fails the test if the channel isn't closed within a second
+func awaitEvent(t *testing.T, event <-chan struct{}, what string) {
+	select {
+	case <-event:
+	case <-time.After(time.Second):
+		t.Fatalf("%s did not happen in time", what)
+	}
+}
+
+// awaitRun returns the result of Run sent to c and fails the test if Run doesn't return within a second
+func awaitRun(t *testing.T, c <-chan error) error {
+	select {
+	case err := <-c:
+		return err
+	case <-time.After(time.Second):
+		t.Fatal("Run did not terminate in time")
+	}
+	return nil
+}
+
+// waitForState waits up to a second for the runner to reach state
+func waitForState(t *testing.T, r *Runner, state ServiceState) {
+	deadline := time.Now().Add(time.Second)
+	for r.LifecycleState() != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("runner is %s, expected %s", r.LifecycleState(), state)
+		}
+		time.Sleep(time.Millisecond)
+	}
 }
 
 func TestRunner(t *testing.T) {
@@ -32,27 +115,32 @@ func TestRunner(t *testing.T) {
 	r := NewRunner()
 	var shutdownComplete bool
 	r.PostShutdown = func(error) { shutdownComplete = true }
+	postInit := make(chan struct{})
+	var initedBeforePostInit bool
+	r.PostInit = func() {
+		initedBeforePostInit = service.didInit()
+		close(postInit)
+	}
 	r.Add(service)
 
 	c := make(chan error)
 	go func() { c <- r.Run() }()
 
-	time.Sleep(1 * time.Millisecond)
-	require.True(t, service.initRun)
+	_, inited := service.events()
+	awaitEvent(t, inited, "init")
+	require.True(t, service.didInit())
+	awaitEvent(t, postInit, "post init")
+	require.True(t, initedBeforePostInit)
 	r.Stop()
-	select {
-	case err := <-c:
-		require.NoError(t, err)
-	case <-time.After(time.Millisecond):
-		t.Error("Run did not terminate in time")
-	}
-	require.True(t, service.shutdownRun)
+	require.NoError(t, awaitRun(t, c))
+	require.True(t, service.didShutdown())
 	require.True(t, shutdownComplete)
 }
 
 func TestRunnerOnInitSignalTimeout(t *testing.T) {
 	s1 := &testService{}
-	s2 := &testService{sleepOnInit: 20 * time.Millisecond}
+	s2 := &testService{blockInit: make(chan struct{})}
+	defer close(s2.blockInit)
 	s3 := &testService{}
 
 	r := NewRunner()
@@ -64,30 +152,20 @@ func TestRunnerOnInitSignalTimeout(t *testing.T) {
 	r.Add(s3)
 
 	c := make(chan error)
-	running := make(chan bool)
-	go func() {
-		running <- true
-		c <- r.Run()
-	}()
-	<-running
-	time.Sleep(2 * time.Millisecond)
+	go func() { c <- r.Run() }()
+	started, _ := s2.events()
+	awaitEvent(t, started, "init of s2")
 	r.Stop()
 
-	select {
-	case err := <-c:
-		require.NoError(t, err)
-	case <-time.After(50 * time.Millisecond):
-		t.Error("Run did not terminate in time")
+	require.NoError(t, awaitRun(t, c))
+	require.True(t, s1.didInit())
+	require.True(t, s1.didShutdown())
 
-	}
-	require.True(t, s1.initRun)
-	require.True(t, s1.shutdownRun)
+	require.False(t, s2.didInit())
+	require.False(t, s2.didShutdown())
 
-	require.False(t, s2.initRun)
-	require.False(t, s2.shutdownRun)
-
-	require.False(t, s3.initRun)
-	require.False(t, s3.shutdownRun)
+	require.False(t, s3.didInit())
+	require.False(t, s3.didShutdown())
 
 	require.True(t, shutdownComplete)
 }
@@ -97,55 +175,690 @@ func TestRunnerErrorOnInit(t *testing.T) {
 	r.Add(service)
 	err := r.Run()
 	require.Error(t, err)
-	require.True(t, service.initRun)
-	require.False(t, service.shutdownRun)
+	require.True(t, service.didInit())
+	require.False(t, service.didShutdown())
 }
 
 func TestRunnerTimeoutOnInit(t *testing.T) {
-	service := &testService{sleepOnInit: 2 * time.Millisecond}
-	config := NewRunnerDefaultConfig()
-	config.InitTimeout = 1 * time.Millisecond
-	r := NewRunnerWithConfig(config)
-	r.Add(service)
-	c := make(chan error)
+	t.Run("still running", func(t *testing.T) {
+		service := &testService{blockInit: make(chan struct{})}
+		defer close(service.blockInit)
+		config := NewRunnerDefaultConfig()
+		config.InitTimeout = 1 * time.Millisecond
+		config.OnInitSignalTimeout = 5 * time.Millisecond
+		r := NewRunnerWithConfig(config)
+		r.Add(service)
+		c := make(chan error)
+		go func() {
+			c <- r.Run()
+		}()
+		err := awaitRun(t, c)
+		require.Error(t, err)
+		require.True(t, isTimeoutError(err))
+		require.Contains(t, err.Error(), "budget=1ms")
+		require.Contains(t, err.Error(), "still running after exceeding its budget by")
+		require.False(t, service.didInit())
+		require.False(t, service.didShutdown())
+	})
+
+	t.Run("finished late", func(t *testing.T) {
+		service := &testService{sleepOnInit: 20 * time.Millisecond}
+		config := NewRunnerDefaultConfig()
+		config.InitTimeout = 1 * time.Millisecond
+		config.PostShutdown = nil
+		r := NewRunnerWithConfig(config)
+		r.Add(service)
+		err := r.Run()
+		require.Error(t, err)
+		require.True(t, isTimeoutError(err))
+		require.Contains(t, err.Error(), ", exceeded its budget by")
+		var te *timeoutError
+		require.True(t, errors.As(err, &te))
+		require.True(t, te.elapsed >= 20*time.Millisecond, "elapsed is the actual init duration")
+		// the service did initialize, so it is shutdown as well
+		require.True(t, service.didInit())
+		require.True(t, service.didShutdown())
+	})
+}
+
+func TestRunnerTimeoutOnShutdown(t *testing.T) {
+	for _, td := range []struct {
+		name        string
+		grace       time.Duration
+		shutdownRun bool
+		msg         string
+	}{
+		{"finished within grace", time.Second, true, ", exceeded its budget by"},
+		{"still running", 0, false, "still running after exceeding its budget by"},
+	} {
+		t.Run(td.name, func(t *testing.T) {
+			service := &testService{sleepOnShutdown: 20 * time.Millisecond}
+			if !td.shutdownRun {
+				service.blockShutdown = make(chan struct{})
+				defer close(service.blockShutdown)
+			}
+			config := NewRunnerDefaultConfig()
+			config.ShutdownTimeout = 1 * time.Millisecond
+			config.ShutdownOverrunGrace = td.grace
+			var timedOut *bool
+			config.PostShutdown = func(err error) {
+				te := isTimeoutError(err)
+				timedOut = &te
+			}
+			r := NewRunnerWithConfig(config)
+			r.Add(service)
+			c := make(chan error)
+			go func() {
+				c <- r.Run()
+			}()
+			r.Stop()
+			err := awaitRun(t, c)
+			require.Error(t, err)
+			require.True(t, isTimeoutError(err))
+			require.Contains(t, err.Error(), td.msg)
+			require.True(t, service.didInit())
+			require.Equal(t, td.shutdownRun, service.didShutdown())
+			require.NotNil(t, timedOut)
+			require.True(t, *timedOut)
+		})
+	}
+}
+
+type recorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recorder) record(event string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recorder) recorded() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+func (r *recorder) waitFor(t *testing.T, event string) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		for _, e := range r.recorded() {
+			if e == event {
+				return
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("event %q was not recorded, got %v", event, r.recorded())
+}
+
+// barrier lets services wait for each other during init to prove they are initialized in parallel
+type barrier struct {
+	wg sync.WaitGroup
+}
+
+func newBarrier(parties int) *barrier {
+	b := &barrier{}
+	b.wg.Add(parties)
+	return b
+}
+
+func (b *barrier) pass() error {
+	b.wg.Done()
+	passed := make(chan struct{})
 	go func() {
-		c <- r.Run()
+		b.wg.Wait()
+		close(passed)
 	}()
 	select {
-	case err := <-c:
+	case <-passed:
+		return nil
+	case <-time.After(time.Second):
+		return errors.New("services were not initialized in parallel")
+	}
+}
+
+type recordingService struct {
+	name    string
+	barrier *barrier
+	rec     *recorder
+}
+
+func (s *recordingService) Init() error {
+	if s.barrier != nil {
+		if err := s.barrier.pass(); err != nil {
+			return err
+		}
+	}
+	s.rec.record("init " + s.name)
+	return nil
+}
+
+func (s *recordingService) Shutdown(os.Signal) {
+	s.rec.record("shutdown " + s.name)
+}
+
+func TestRunnerParallelInit(t *testing.T) {
+	rec := &recorder{}
+	parallel := newBarrier(2)
+	a := &recordingService{name: "a", barrier: parallel, rec: rec}
+	b := &recordingService{name: "b", barrier: parallel, rec: rec}
+	c := &recordingService{name: "c", rec: rec}
+
+	r := NewRunner()
+	r.PostShutdown = nil
+	r.Add(c, DependsOn(a, b))
+	r.Add(a, DependsOn())
+	r.Add(b, DependsOn())
+
+	done := make(chan error)
+	go func() { done <- r.Run() }()
+
+	rec.waitFor(t, "init c")
+	r.Stop()
+	require.NoError(t, awaitRun(t, done))
+
+	events := rec.recorded()
+	require.Len(t, events, 6)
+	require.ElementsMatch(t, []string{"init a", "init b"}, events[:2])
+	require.Equal(t, "init c", events[2])
+	require.Equal(t, "shutdown c", events[3])
+	// a and b are shutdown in reverse order of their init results, which can differ from the recorded order
+	require.ElementsMatch(t, []string{"shutdown a", "shutdown b"}, events[4:])
+}
+
+func TestRunnerDependencyCycle(t *testing.T) {
+	rec := &recorder{}
+	a := &recordingService{name: "a", rec: rec}
+	b := &recordingService{name: "b", rec: rec}
+
+	r := NewRunner()
+	r.PostShutdown = nil
+	r.Add(a, DependsOn(b))
+	r.Add(b, DependsOn(a))
+
+	err := r.Run()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "dependency cycle")
+	require.Empty(t, rec.recorded())
+}
+
+func TestRunnerUnknownDependency(t *testing.T) {
+	rec := &recorder{}
+	r := NewRunner()
+	r.PostShutdown = nil
+	r.Add(&recordingService{name: "a", rec: rec}, DependsOn(&recordingService{name: "b", rec: rec}))
+
+	err := r.Run()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "was not added to the runner")
+}
+
+type budgetedService struct {
+	testService
+	initBudget     time.Duration
+	shutdownBudget time.Duration
+}
+
+func (s *budgetedService) InitBudget() time.Duration     { return s.initBudget }
+func (s *budgetedService) ShutdownBudget() time.Duration { return s.shutdownBudget }
+
+func TestRunnerPerServiceInitTimeout(t *testing.T) {
+	config := NewRunnerDefaultConfig()
+	config.InitTimeout = time.Millisecond
+	config.OnInitSignalTimeout = 5 * time.Millisecond
+	config.PostShutdown = nil
+
+	// run runs r until all services are initialized
+	run := func(t *testing.T, r *Runner) {
+		c := make(chan error)
+		go func() { c <- r.Run() }()
+		waitForState(t, r, StateInitialized)
+		r.Stop()
+		require.NoError(t, awaitRun(t, c))
+	}
+
+	t.Run("option", func(t *testing.T) {
+		service := &testService{sleepOnInit: 5 * time.Millisecond}
+		r := NewRunnerWithConfig(config)
+		r.Add(service, WithInitTimeout(time.Second))
+		run(t, r)
+		require.True(t, service.didInit())
+	})
+
+	t.Run("interface", func(t *testing.T) {
+		service := &budgetedService{testService: testService{sleepOnInit: 5 * time.Millisecond}, initBudget: time.Second}
+		r := NewRunnerWithConfig(config)
+		r.Add(service)
+		run(t, r)
+		require.True(t, service.didInit())
+	})
+
+	t.Run("option takes precedence over interface", func(t *testing.T) {
+		service := &budgetedService{testService: testService{blockInit: make(chan struct{})}, initBudget: time.Second}
+		defer close(service.blockInit)
+		r := NewRunnerWithConfig(config)
+		r.Add(service, WithInitTimeout(time.Millisecond))
+		err := r.Run()
 		require.Error(t, err)
 		require.True(t, isTimeoutError(err))
-	case <-time.After(2 * time.Millisecond):
-		t.Error("Run did not terminate in time")
-	}
-	require.False(t, service.initRun)
-	require.False(t, service.shutdownRun)
+	})
 }
-func TestRunnerTimeoutOnShutdown(t *testing.T) {
-	service := &testService{sleepOnShutdown: 2 * time.Millisecond}
+
+func TestRunnerPerServiceShutdownTimeout(t *testing.T) {
+	fast := &budgetedService{testService: testService{sleepOnShutdown: 5 * time.Millisecond}, shutdownBudget: time.Second}
+	slow := &budgetedService{testService: testService{blockShutdown: make(chan struct{})}, shutdownBudget: time.Millisecond}
+	defer close(slow.blockShutdown)
+
 	config := NewRunnerDefaultConfig()
-	config.ShutdownTimeout = 1 * time.Millisecond
-	var timedOut *bool
-	config.PostShutdown = func(err error) {
-		te := isTimeoutError(err)
-		timedOut = &te
+	config.ShutdownTimeout = time.Millisecond
+	config.ShutdownOverrunGrace = 0
+	var shutdownErr error
+	config.PostShutdown = func(err error) { shutdownErr = err }
+	r := NewRunnerWithConfig(config)
+	r.Add(slow)
+	r.Add(fast)
+
+	c := make(chan error)
+	go func() { c <- r.Run() }()
+	waitForState(t, r, StateInitialized)
+	require.True(t, fast.didInit())
+	r.Stop()
+
+	err := awaitRun(t, c)
+	require.Error(t, err)
+	require.True(t, isTimeoutError(err))
+	require.Equal(t, err, shutdownErr)
+	require.Contains(t, err.Error(), "service=service.budgetedService budget=1ms")
+	require.True(t, fast.didShutdown())
+	require.False(t, slow.didShutdown())
+}
+
+type healthyService struct {
+	testService
+	err error
+}
+
+func (s *healthyService) Healthy() error { return s.err }
+
+// checkRecorder records the health checks the runner registers from its go routine
+type checkRecorder struct {
+	mu     sync.Mutex
+	checks map[string]HealthCheckable
+}
+
+func (c *checkRecorder) AddCheck(name string, checkable interface{ Healthy() error }) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.checks == nil {
+		c.checks = map[string]HealthCheckable{}
 	}
+	c.checks[name] = checkable
+}
+
+func (c *checkRecorder) get(name string) HealthCheckable {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.checks[name]
+}
+
+func (c *checkRecorder) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.checks)
+}
+
+func TestRunnerHealthChecks(t *testing.T) {
+	plain := &testService{}
+	unhealthy := &healthyService{err: errors.New("not healthy")}
+	checks := &checkRecorder{}
+
+	r := NewRunner()
+	r.PostShutdown = nil
+	r.UseHealthChecks(checks)
+	r.Add(plain)
+	r.Add(unhealthy)
+
+	c := make(chan error)
+	go func() { c <- r.Run() }()
+	waitForState(t, r, StateInitialized)
+
+	require.Equal(t, 3, checks.len())
+	require.NoError(t, checks.get(RunnerHealthCheckName).Healthy())
+	require.NoError(t, checks.get("service.testService").Healthy())
+	require.EqualError(t, checks.get("service.healthyService").Healthy(), "not healthy")
+	require.Equal(t, StateInitialized, checks.get("service.testService").(LifecycleReporter).LifecycleState())
+
+	r.Stop()
+	require.NoError(t, awaitRun(t, c))
+	require.Equal(t, StateShutdown, checks.get("service.testService").(LifecycleReporter).LifecycleState())
+	require.EqualError(t, checks.get("service.healthyService").Healthy(), "service is shutdown")
+	require.EqualError(t, checks.get(RunnerHealthCheckName).Healthy(), "runner is shutdown")
+}
+
+func TestRunnerHealthCheckFailsOnShutdown(t *testing.T) {
+	checks := &checkRecorder{}
+	var runnerCheck error
+	service := &testService{}
+
+	r := NewRunner()
+	r.PostShutdown = nil
+	r.UseHealthChecks(checks)
+	r.Add(service)
+	r.Add(&shutdownHook{f: func() { runnerCheck = checks.get(RunnerHealthCheckName).Healthy() }})
+
+	c := make(chan error)
+	go func() { c <- r.Run() }()
+	waitForState(t, r, StateInitialized)
+	require.NoError(t, checks.get(RunnerHealthCheckName).Healthy())
+
+	r.Stop()
+	require.NoError(t, awaitRun(t, c))
+	require.EqualError(t, runnerCheck, "runner is shutting down")
+}
+
+type shutdownHook struct {
+	f func()
+}
+
+func (s *shutdownHook) Init() error          { return nil }
+func (s *shutdownHook) Shutdown(_ os.Signal) { s.f() }
+
+type drainableService struct {
+	testService
+	drained chan struct{}
+}
+
+func (s *drainableService) Drain() { close(s.drained) }
+
+func TestRunnerLameDuck(t *testing.T) {
+	checks := &checkRecorder{}
+	service := &drainableService{drained: make(chan struct{})}
+
+	config := NewRunnerDefaultConfig()
+	config.LameDuckPeriod = 20 * time.Millisecond
+	config.PostShutdown = nil
 	r := NewRunnerWithConfig(config)
+	r.UseHealthChecks(checks)
 	r.Add(service)
+
 	c := make(chan error)
-	go func() {
-		c <- r.Run()
-	}()
+	go func() { c <- r.Run() }()
+	waitForState(t, r, StateInitialized)
+	stopped := time.Now()
+	r.Stop()
+
+	awaitEvent(t, service.drained, "drain")
+	require.EqualError(t, checks.get(RunnerHealthCheckName).Healthy(), "runner is draining")
+	require.NoError(t, checks.get("service.drainableService").Healthy())
+
+	require.NoError(t, awaitRun(t, c))
+	require.True(t, service.didShutdown())
+	require.True(t, time.Since(stopped) >= config.LameDuckPeriod)
+}
+
+func TestRunnerLameDuckEndsOnSecondSignal(t *testing.T) {
+	config := NewRunnerDefaultConfig()
+	config.LameDuckPeriod = time.Minute
+	config.PostShutdown = nil
+	r := NewRunnerWithConfig(config)
+	r.Add(&testService{})
+
+	c := make(chan error)
+	go func() { c <- r.Run() }()
+	waitForState(t, r, StateInitialized)
 	r.Stop()
+	r.Stop()
+
+	require.NoError(t, awaitRun(t, c))
+}
+
+type contextService struct {
+	startBlocks  bool
+	started      chan struct{}
+	startErr     chan error
+	stopDeadline time.Duration
+	stopSignal   os.Signal
+	stopped      bool
+}
+
+func (s *contextService) Start(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		return errors.New("start context has no deadline")
+	}
+	if s.startBlocks {
+		close(s.started)
+		<-ctx.Done()
+		s.startErr <- ctx.Err()
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (s *contextService) Stop(ctx context.Context) error {
+	deadline, _ := ctx.Deadline()
+	s.stopDeadline = time.Until(deadline)
+	s.stopSignal = SignalFromContext(ctx)
+	s.stopped = true
+	return nil
+}
+
+func TestRunnerContextService(t *testing.T) {
+	cs := &contextService{}
+	legacy := &testService{}
+
+	r := NewRunner()
+	r.PostShutdown = nil
+	r.AddContextService(cs, WithShutdownTimeout(time.Hour))
+	r.Add(legacy, DependsOn(cs))
+
+	c := make(chan error)
+	go func() { c <- r.Run() }()
+	waitForState(t, r, StateInitialized)
+	require.True(t, legacy.didInit())
+	r.Stop()
+	require.NoError(t, awaitRun(t, c))
+
+	require.True(t, legacy.didShutdown())
+	require.True(t, cs.stopped)
+	require.Equal(t, syscall.SIGQUIT, cs.stopSignal)
+	require.True(t, cs.stopDeadline > 59*time.Minute)
+}
+
+func TestRunnerContextServiceCancelledOnSignal(t *testing.T) {
+	cs := &contextService{startBlocks: true, started: make(chan struct{}), startErr: make(chan error, 1)}
+
+	r := NewRunner()
+	r.PostShutdown = nil
+	r.AddContextService(cs)
+
+	c := make(chan error)
+	go func() { c <- r.Run() }()
+	awaitEvent(t, cs.started, "start")
+	r.Stop()
+
 	select {
-	case err := <-c:
-		require.Error(t, err)
-		require.True(t, isTimeoutError(err))
-	case <-time.After(3 * time.Millisecond):
-		t.Error("Run did not terminate in time")
+	case err := <-cs.startErr:
+		require.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("start context was not cancelled")
 	}
-	require.True(t, service.initRun)
-	require.False(t, service.shutdownRun)
-	require.NotNil(t, timedOut)
-	require.True(t, *timedOut)
+	// an init aborted by the signal is no failure
+	require.NoError(t, awaitRun(t, c))
+	require.False(t, cs.stopped)
+}
+
+func TestRunnerContextServiceCancelledOnFailure(t *testing.T) {
+	cs := &contextService{startBlocks: true, started: make(chan struct{}), startErr: make(chan error, 1)}
+	failing := &testService{errOnInit: errors.New("failed")}
+
+	r := NewRunner()
+	r.PostShutdown = nil
+	r.InitTimeout = time.Hour
+	r.OnInitSignalTimeout = time.Hour
+	r.AddContextService(cs, DependsOn())
+	r.Add(failing, DependsOn())
+
+	c := make(chan error)
+	go func() { c <- r.Run() }()
+
+	select {
+	case err := <-cs.startErr:
+		require.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("start context was not cancelled")
+	}
+	err := awaitRun(t, c)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "service init failed for service.testService: failed")
+	require.False(t, cs.stopped)
+}
+
+func TestRunnerContextServiceFailingAfterSignal(t *testing.T) {
+	cs := &failingContextService{started: make(chan struct{})}
+
+	r := NewRunner()
+	r.PostShutdown = nil
+	r.AddContextService(cs)
+
+	c := make(chan error)
+	go func() { c <- r.Run() }()
+	awaitEvent(t, cs.started, "start")
+	r.Stop()
+
+	err := awaitRun(t, c)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "service init failed for service.failingContextService: connection refused")
+}
+
+// failingContextService fails with its own error once its start context is cancelled
+type failingContextService struct {
+	started chan struct{}
+}
+
+func (s *failingContextService) Start(ctx context.Context) error {
+	close(s.started)
+	<-ctx.Done()
+	return errors.New("connection refused")
+}
+
+func (s *failingContextService) Stop(context.Context) error { return nil }
+
+type reloadableService struct {
+	recordingService
+	errOnReload error
+}
+
+func (s *reloadableService) Reload() error {
+	s.rec.record("reload " + s.name)
+	return s.errOnReload
+}
+
+func TestRunnerReload(t *testing.T) {
+	rec := &recorder{}
+	config := NewRunnerDefaultConfig()
+	config.PostShutdown = nil
+	r := NewRunnerWithConfig(config)
+	r.Add(&reloadableService{recordingService: recordingService{name: "a", rec: rec}})
+	r.Add(&recordingService{name: "b", rec: rec})
+	r.Add(&reloadableService{recordingService: recordingService{name: "c", rec: rec}, errOnReload: errors.New("bad config")})
+	r.Add(&reloadableService{recordingService: recordingService{name: "d", rec: rec}})
+	require.Nil(t, r.LastReload())
+
+	c := make(chan error)
+	go func() { c <- r.Run() }()
+	rec.waitFor(t, "init d")
+
+	r.Reload()
+	rec.waitFor(t, "reload d")
+	require.Equal(t, []string{
+		"init a", "init b", "init c", "init d",
+		"reload a", "reload c", "reload d",
+	}, rec.recorded())
+
+	report := r.LastReload()
+	require.NotNil(t, report)
+	require.Len(t, report.Results, 3)
+	require.Equal(t, "service.reloadableService", report.Results[0].Service)
+	require.Empty(t, report.Results[0].Error)
+	require.Equal(t, "bad config", report.Results[1].Error)
+	require.Empty(t, report.Results[2].Error)
+
+	r.Stop()
+	require.NoError(t, <-c)
+}
+
+func TestRunnerReloadOnSIGHUP(t *testing.T) {
+	rec := &recorder{}
+	config := NewRunnerDefaultConfig()
+	config.PostShutdown = nil
+	r := NewRunnerWithConfig(config)
+	r.Add(&reloadableService{recordingService: recordingService{name: "a", rec: rec}})
+
+	c := make(chan error)
+	go func() { c <- r.Run() }()
+	rec.waitFor(t, "init a")
+
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	rec.waitFor(t, "reload a")
+	require.NotContains(t, rec.recorded(), "shutdown a")
+
+	r.Stop()
+	require.NoError(t, <-c)
+	require.Equal(t, []string{"init a", "reload a", "shutdown a"}, rec.recorded())
+}
+
+type storage struct{ recordingService }
+type cache struct{ recordingService }
+type api struct {
+	recordingService
+	cache *cache
+}
+
+type apiParams struct {
+	registry.Params
+	Storage *storage
+	// the api runs the cache itself
+	Cache *cache `registry:"nolifecycle"`
+}
+
+func TestRunnerWithRegistryAddsCreatedServices(t *testing.T) {
+	rec := &recorder{}
+	r := NewRunnerWithRegistry()
+	r.PostShutdown = nil
+	_, err := r.Register(func() (*storage, error) { return &storage{recordingService{name: "storage", rec: rec}}, nil })
+	require.NoError(t, err)
+	_, err = r.Register(func() (*cache, error) { return &cache{recordingService{name: "cache", rec: rec}}, nil })
+	require.NoError(t, err)
+	_, err = r.Register(func(p *apiParams) (*api, error) {
+		return &api{recordingService{name: "api", rec: rec}, p.Cache}, nil
+	})
+	require.NoError(t, err)
+
+	var a *api
+	require.NoError(t, r.RequestAndSet(&a))
+	// adding a service twice does nothing
+	r.Add(a)
+	r.Create(&a)
+
+	require.Equal(t, "service.storage,service.api", joinedServiceNames(r.services))
+
+	r.Create(&a.cache)
+	require.Equal(t, "service.storage,service.api,service.cache", joinedServiceNames(r.services))
+}
+
+func TestNewDefaultHealthCheckerServiceAddsHealthChecker(t *testing.T) {
+	r := NewRunnerWithRegistry()
+	hc, err := NewDefaultHealthCheckerService(r, metrics.NewRegistry())
+	require.NoError(t, err)
+	require.Equal(t, "service.HealthChecker", joinedServiceNames(r.services))
+
+	// the runner doesn't add it again if the registry creates it
+	_, err = r.Register(func() (*HealthChecker, error) { return hc, nil })
+	require.NoError(t, err)
+	var created *HealthChecker
+	require.NoError(t, r.RequestAndSet(&created))
+	require.Equal(t, "service.HealthChecker", joinedServiceNames(r.services))
 }
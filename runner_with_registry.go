@@ -1,16 +1,23 @@
 package service
 
 import (
+	"fmt"
 	"reflect"
 
 	"github.com/remerge/go-service/registry"
 )
 
+// NewRunnerWithRegistry creates a runner that runs every Service and ContextService created as a
+// singleton by its registry. They are added in the order they are created, which is their
+// dependency order. Values required by a Params struct field tagged with `registry:"nolifecycle"`
+// are not added, the consumer runs them itself.
 func NewRunnerWithRegistry() *RunnerWithRegistry {
-	return &RunnerWithRegistry{
+	r := &RunnerWithRegistry{
 		Registry: registry.New(),
 		Runner:   NewRunner(),
 	}
+	r.OnCreate(r.created)
+	return r
 }
 
 type RunnerWithRegistry struct {
@@ -19,17 +26,73 @@ type RunnerWithRegistry struct {
 }
 
 // Create creates an instance and sets s (which must be a pointer) to the new instance given
-// a set of parameters. Furthermore it adds the new object to the list of executed services.
+// a set of parameters. Furthermore it adds the new object to the list of executed services,
+// even if it was created for a field tagged with `registry:"nolifecycle"` before.
+// If ParallelInit is enabled the service depends on all services it was constructed from,
+// otherwise it depends on all services added before.
 func (r *RunnerWithRegistry) Create(s interface{}, params ...interface{}) {
-	err := r.RequestAndSet(s, params...)
+	r.CreateNamed("", s, params...)
+}
+
+// CreateNamed works like Create but uses the provider registered with the given name
+// (see registry.RegisterNamed). The service is named after its type and the given name.
+func (r *RunnerWithRegistry) CreateNamed(name string, s interface{}, params ...interface{}) {
+	err := r.RequestAndSetNamed(s, name, params...)
 	if err != nil {
 		panic(err)
 	}
-	// s is a pointer to a pointer to a type instance implementing a Service
+	// s is a pointer to a pointer to a type instance implementing a Service or ContextService
 	// TODO: how to cast this without reflections? Am I stupid?
-	sv := reflect.ValueOf(s).Elem().Interface().(Service)
-	r.Add(sv)
+	v := reflect.ValueOf(s).Elem().Interface()
+	if !r.addCreated(v, name) {
+		panic(fmt.Sprintf("%T is neither a Service nor a ContextService", v))
+	}
+}
+
+// created is called by the registry for every created singleton
+func (r *RunnerWithRegistry) created(v interface{}, name string) {
+	switch v.(type) {
+	case Service, ContextService:
+	default:
+		return
+	}
+	if r.LifecycleState() != StatePending {
+		r.log.Warnf("service %s was created after the runner was started and will not be run", serviceName(v))
+		return
+	}
+	r.addCreated(v, name)
+}
 
+// addCreated adds v created by the registry to the runner if it is a Service or a ContextService
+func (r *RunnerWithRegistry) addCreated(v interface{}, name string) bool {
+	var opts []ServiceOption
+	if name != "" {
+		opts = append(opts, WithName(fmt.Sprintf("%s[%s]", serviceName(v), name)))
+	}
+	if r.ParallelInit {
+		opts = append(opts, dependsOnIfAdded(r.serviceDependencies(v)...))
+	}
+	switch s := v.(type) {
+	case ContextService:
+		r.AddContextService(s, opts...)
+	case Service:
+		r.Add(s, opts...)
+	default:
+		return false
+	}
+	return true
+}
+
+// serviceDependencies returns all services the registry used to construct s
+func (r *RunnerWithRegistry) serviceDependencies(s interface{}) []interface{} {
+	var services []interface{}
+	for _, dep := range r.Dependencies(s) {
+		switch dep.(type) {
+		case Service, ContextService:
+			services = append(services, dep)
+		}
+	}
+	return services
 }
 
 func (r *RunnerWithRegistry) CreateOrdered(services ...interface{}) {
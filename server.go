@@ -1,6 +1,7 @@
 package service
 
 import (
+	"crypto/tls"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -37,29 +38,40 @@ type Server struct {
 		Cert   string
 		Key    string
 		Server *graceful.Server
+
+		certificate atomic.Value // *tls.Certificate
 	}
 
+	middlewares []ServerMiddleware
+
 	requestsWg sync.WaitGroup
 	closing    uint32
+	draining   uint32
 }
 
 type ServerConfig struct {
 	Port int
 }
 
+// ServerMiddleware is a gin middleware used by the Server. Constructors returning a ServerMiddleware
+// can be registered with RegisterGroup to add the middleware to the Server created by the registry.
+type ServerMiddleware gin.HandlerFunc
+
 type serverParams struct {
 	registry.Params
 	ServerConfig `registry:"lazy"`
 	Log          cue.Logger
 	Cmd          *cobra.Command
+	Middlewares  []ServerMiddleware `registry:"group"`
 }
 
 func registerServer(r Registry, name string) {
 	r.Register(func(p *serverParams) (*Server, error) {
 		f := &Server{
-			Port: p.Port,
-			log:  p.Log,
-			Name: name,
+			Port:        p.Port,
+			log:         p.Log,
+			Name:        name,
+			middlewares: p.Middlewares,
 		}
 		f.configureFlags(p.Cmd)
 		return f, nil
@@ -104,19 +116,57 @@ func (s *Server) configureFlags(cmd *cobra.Command) {
 		"server-tls-key", "",
 		"HTTPS server certificate key",
 	)
+	MarkSecret(flags, "server-tls-key")
 }
 
 func (s *Server) Init() error {
 	gin.SetMode("release")
 	s.Engine = gin.New()
 	s.Engine.Use(
-		ginRequestsWaiter(s.Name, &s.requestsWg, &s.closing),
+		ginRequestsWaiter(s.Name, &s.requestsWg, &s.closing, &s.draining),
 		ginRecovery(s.Name),
 		ginLogger(s.Name),
 	)
+	for _, m := range s.middlewares {
+		s.Engine.Use(gin.HandlerFunc(m))
+	}
+	return nil
+}
+
+// Drain makes the server ask clients to close their connections by adding a "Connection: close"
+// header to every response. Requests are served as usual.
+func (s *Server) Drain() {
+	s.log.Info("server draining")
+	atomic.StoreUint32(&s.draining, 1)
+}
+
+// Reload reloads the TLS certificate and key from `service.Server.TLS.Cert` and `service.Server.TLS.Key`.
+// New connections use the new certificate, on failure the previous certificate is kept. The paths
+// are reloaded with the config before (see Config.Reload), all other flags only apply on startup.
+func (s *Server) Reload() error {
+	if s.TLS.Port == 0 {
+		return nil
+	}
+	if err := s.loadCertificate(); err != nil {
+		return err
+	}
+	s.log.Info("tls certificate reloaded")
+	return nil
+}
+
+func (s *Server) loadCertificate() error {
+	cert, err := tls.LoadX509KeyPair(s.TLS.Cert, s.TLS.Key)
+	if err != nil {
+		return err
+	}
+	s.TLS.certificate.Store(&cert)
 	return nil
 }
 
+func (s *Server) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.TLS.certificate.Load().(*tls.Certificate), nil
+}
+
 func (s *Server) Shutdown(os.Signal) {
 	var serverChan, tlsServerChan <-chan struct{}
 
@@ -186,17 +236,25 @@ func (s *Server) Serve(handler http.Handler) {
 // ServeTLS starts a TLS encrypted HTTPS server on `service.Server.TLS.Port`.
 // TLS support is disabled by default and needs to be configured with proper
 // certificates in `service.Server.TLS.Key` and `service.Server.TLS.Cert`.
+// The certificates are reloaded on Reload.
 func (s *Server) ServeTLS(handler http.Handler) {
 	if handler == nil {
 		handler = s.Engine
 	}
 
+	if err := s.loadCertificate(); err != nil {
+		s.log.Panic(err, "tls server failed")
+	}
+
 	s.TLS.Server = &graceful.Server{
 		Timeout: s.ShutdownTimeout,
 		Server: &http.Server{
 			Handler:  handler,
 			Addr:     fmt.Sprintf(":%d", s.TLS.Port),
 			ErrorLog: discardLog,
+			TLSConfig: &tls.Config{
+				GetCertificate: s.getCertificate,
+			},
 		},
 		NoSignalHandling: true,
 	}
@@ -208,10 +266,6 @@ func (s *Server) ServeTLS(handler http.Handler) {
 		"listen": s.TLS.Server.Addr,
 	}).Info("start tls server")
 
-	s.log.Panic(
-		s.TLS.Server.ListenAndServeTLS(
-			s.TLS.Cert,
-			s.TLS.Key,
-		), "tls server failed",
-	)
+	// the certificate is provided by TLSConfig.GetCertificate
+	s.log.Panic(s.TLS.Server.ListenAndServeTLS("", ""), "tls server failed")
 }
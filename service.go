@@ -1,18 +1,59 @@
 package service
 
-import "os"
+import (
+	"context"
+	"os"
+	"time"
+)
 
 // Service does not need a run method anymore as there is no usecase that can't be handled in init
 // Init has to do some initialization and needs  to return. Functionality that needs to run in the background should be scheduled as go routines
+// or implemented as a Runnable supervised by a Worker
 type Service interface {
 	Init() error
 	Shutdown(sig os.Signal)
 }
 
+// ContextService is a Service with a context aware lifecycle. The context passed to Start carries the
+// init budget of the service as deadline and is cancelled if the Runner receives a signal or another service
+// fails during init. Returning the context.Canceled error of a cancelled context aborts the init without failing Run. The
+// context is only valid until Start returns, background work needs to be stopped in Stop. The context passed
+// to Stop carries the shutdown budget as deadline and the signal that caused the shutdown (see SignalFromContext).
+type ContextService interface {
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+}
+
 type Initable interface {
 	Init() error
 }
 
+// InitBudgeter can be implemented by services that need a different init timeout than
+// the one configured in RunnerConfig
+type InitBudgeter interface {
+	InitBudget() time.Duration
+}
+
+// ShutdownBudgeter can be implemented by services that need a different shutdown timeout than
+// the one configured in RunnerConfig
+type ShutdownBudgeter interface {
+	ShutdownBudget() time.Duration
+}
+
+// Reloadable can be implemented by services that are able to re-read their configuration at runtime.
+// The Runner calls Reload on SIGHUP. If Reload fails the service has to keep its previous configuration.
+// Services run by Cmd see the reloaded flag values, the Config is reloaded first (see Config.Reload).
+type Reloadable interface {
+	Reload() error
+}
+
+// Drainable can be implemented by services that need to know when the Runner enters its lame duck
+// period, e.g. to ask clients to close their connections
+type Drainable interface {
+	Drain()
+}
+
+// Runnable is a long running task that can be supervised and restarted by a Worker
 type Runnable interface {
 	Run() error
 }
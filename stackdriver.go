@@ -18,7 +18,7 @@ type stackdriver struct {
 	name              string
 }
 
-func newStackdriverService(r *RunnerWithRegistry, log cue.Logger, cmd *cobra.Command, name string) (*stackdriver, error) {
+func newStackdriverService(log cue.Logger, cmd *cobra.Command, name string) (*stackdriver, error) {
 	s := &stackdriver{
 		log:  log,
 		name: name,
@@ -28,7 +28,6 @@ func newStackdriverService(r *RunnerWithRegistry, log cue.Logger, cmd *cobra.Com
 		"enable-stackdriver", s.enableStackdriver,
 		"Enable stackdriver",
 	)
-	r.Add(s)
 	return s, nil
 }
 
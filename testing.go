@@ -0,0 +1,32 @@
+package service
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// NewTestRunnerWithRegistry creates a RunnerWithRegistry with all Base providers registered like Cmd
+// does, but without a command line. The given constructors replace the providers of the types they
+// create (see registry.Override), e.g. to use a fake *Tracker that doesn't connect to Kafka:
+//
+//	r, err := service.NewTestRunnerWithRegistry("test", func() (*service.Tracker, error) {
+//		return &service.Tracker{Tracker: fakeTracker}, nil
+//	})
+//
+// Base is instantiated to register its providers, all other providers can still be overridden or
+// decorated (see registry.Decorate) on the returned registry.
+func NewTestRunnerWithRegistry(name string, overrides ...interface{}) (*RunnerWithRegistry, error) {
+	r := NewRunnerWithRegistry()
+	registerCmd(r, &cobra.Command{Use: name}, NewConfig(name))
+	RegisterBase(r.Registry, name)
+
+	var base *Base
+	if err := r.RequestAndSet(&base); err != nil {
+		return nil, err
+	}
+	for _, ctor := range overrides {
+		if _, err := r.Override(ctor); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/remerge/cue"
+	"github.com/stretchr/testify/require"
+)
+
+type decoratedLogger struct {
+	cue.Logger
+}
+
+func TestNewTestRunnerWithRegistry(t *testing.T) {
+	fake := &Tracker{Name: "fake"}
+	r, err := NewTestRunnerWithRegistry("test", func() (*Tracker, error) {
+		return fake, nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, r.Decorate(func(l cue.Logger) (cue.Logger, error) {
+		return &decoratedLogger{l}, nil
+	}))
+
+	var tracker *Tracker
+	require.NoError(t, r.RequestAndSet(&tracker))
+	require.True(t, tracker == fake)
+
+	var log cue.Logger
+	require.NoError(t, r.RequestAndSet(&log))
+	require.IsType(t, &decoratedLogger{}, log)
+
+	// Base was already created, so it can't be replaced anymore
+	_, err = NewTestRunnerWithRegistry("test", func() (*Base, error) { return &Base{}, nil })
+	require.EqualError(t, err, "can not override *service.Base, it was already instantiated")
+}
@@ -31,6 +31,10 @@ func NewTracker(log cue.Logger, cmd *cobra.Command, name string) (*Tracker, erro
 	return t, nil
 }
 
+// NewTrackerService creates a tracker and adds it to the runner.
+//
+// Deprecated: the runner runs every service created by the registry (see NewRunnerWithRegistry),
+// register NewTracker instead.
 func NewTrackerService(r *RunnerWithRegistry, log cue.Logger, cmd *cobra.Command, name string) (*Tracker, error) {
 	t, err := NewTracker(log, cmd, name)
 	if err != nil {
@@ -38,7 +42,6 @@ func NewTrackerService(r *RunnerWithRegistry, log cue.Logger, cmd *cobra.Command
 	}
 	r.Add(t)
 	return t, nil
-
 }
 
 func (t *Tracker) configureFlags(cmd *cobra.Command) {
@@ -55,7 +58,11 @@ func (t *Tracker) configureFlags(cmd *cobra.Command) {
 	)
 }
 
+// Init connects the tracker to Kafka unless a gotracker.Tracker was set before, e.g. a fake in tests
 func (t *Tracker) Init() error {
+	if t.Tracker != nil {
+		return nil
+	}
 	t.EventMetadata.Service = t.Name
 	t.EventMetadata.Environment = env.Env
 	t.EventMetadata.Host = fqdn.Get()
@@ -0,0 +1,222 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/remerge/cue"
+)
+
+// RestartPolicy decides if a Worker restarts its Runnable after Run returned and how long it waits
+// before doing so. err is the error returned by Run (or the recovered panic) and failures is the number
+// of consecutive failed runs including the current one.
+type RestartPolicy func(err error, failures int) (delay time.Duration, restart bool)
+
+// RestartAlways restarts the Runnable after every return of Run, regardless of the result.
+func RestartAlways(delay time.Duration) RestartPolicy {
+	return func(error, int) (time.Duration, bool) {
+		return delay, true
+	}
+}
+
+// RestartOnFailure restarts the Runnable only if Run failed with an error or a panic.
+func RestartOnFailure(delay time.Duration) RestartPolicy {
+	return func(err error, _ int) (time.Duration, bool) {
+		return delay, err != nil
+	}
+}
+
+// RestartWithBackoff restarts the Runnable if Run failed. The delay starts at min and is doubled with every
+// consecutive failure up to max.
+func RestartWithBackoff(min, max time.Duration) RestartPolicy {
+	return func(err error, failures int) (time.Duration, bool) {
+		if err == nil {
+			return 0, false
+		}
+		delay := min
+		for i := 1; i < failures && delay < max; i++ {
+			delay *= 2
+		}
+		if delay > max {
+			delay = max
+		}
+		return delay, true
+	}
+}
+
+// Worker is a ContextService that supervises a Runnable. On Start it starts Run in the background and
+// restarts it according to its RestartPolicy. Panics are recovered and reported like errors. On Stop no
+// further restarts happen and Stop waits for Run to return until the shutdown budget is used up. A Runnable
+// needs to implement Shutdownable to be stopped, otherwise Stop only prevents restarts and Run keeps running
+// until it returns by itself. Worker also implements Service for use without a Runner, its Shutdown waits
+// for Run without a deadline.
+type Worker struct {
+	Name string
+
+	runnable Runnable
+	policy   RestartPolicy
+	log      cue.Logger
+	restarts metrics.Counter
+	failures metrics.Counter
+
+	mu      sync.Mutex
+	lastErr error
+	running bool
+
+	// startOnce guards the start of the loop, started is only set by it
+	startOnce sync.Once
+	started   bool
+	stopOnce  sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// NewWorker creates a Worker supervising r. Restarts and failures are counted in the given metrics registry.
+func NewWorker(name string, r Runnable, policy RestartPolicy, registry metrics.Registry) *Worker {
+	return &Worker{
+		Name:     name,
+		runnable: r,
+		policy:   policy,
+		log:      NewLogger("worker").WithValue("worker", name),
+		restarts: metrics.GetOrRegisterCounter(DescribeMetric(MetricName("go_service", "worker_restarts", Label{"worker", name}), "Number of worker restarts", ""), registry),
+		failures: metrics.GetOrRegisterCounter(DescribeMetric(MetricName("go_service", "worker_failures", Label{"worker", name}), "Number of worker failures", ""), registry),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Supervise creates a Worker for rn and adds it to the runner. The metrics registry is requested from the
+// registry, if there is none the default registry is used.
+func (r *RunnerWithRegistry) Supervise(name string, rn Runnable, policy RestartPolicy, opts ...ServiceOption) *Worker {
+	var mr metrics.Registry
+	if err := r.RequestAndSet(&mr); err != nil {
+		mr = metrics.DefaultRegistry
+	}
+	w := NewWorker(name, rn, policy, mr)
+	r.AddContextService(w, opts...)
+	return w
+}
+
+// Init starts the worker. Calling it more than once or after Stop has no effect.
+func (w *Worker) Init() error {
+	w.startOnce.Do(func() {
+		w.started = true
+		w.setRunning(true, nil)
+		go w.loop()
+	})
+	return nil
+}
+
+func (w *Worker) Shutdown(sig os.Signal) {
+	_ = w.Stop(withSignal(context.Background(), sig))
+}
+
+// Start starts the worker, see Init
+func (w *Worker) Start(context.Context) error {
+	return w.Init()
+}
+
+// Stop prevents further restarts, stops the Runnable if it implements Shutdownable and waits for Run to
+// return. It fails if Run didn't return before ctx is done. Calling it more than once is safe. If the worker
+// was never started Stop returns at once and the worker can't be started anymore.
+func (w *Worker) Stop(ctx context.Context) error {
+	w.startOnce.Do(func() {})
+	if !w.started {
+		return nil
+	}
+	first := false
+	w.stopOnce.Do(func() {
+		first = true
+		close(w.stop)
+	})
+	if s, ok := w.runnable.(Shutdownable); ok && first {
+		if err := s.Stop(SignalFromContext(ctx)); err != nil {
+			_ = w.log.Error(err, "worker stop failed")
+		}
+	}
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("worker %s did not stop: %v", w.Name, ctx.Err())
+	}
+}
+
+// Healthy fails if the worker stopped running because of a failure or is waiting to be restarted after one.
+func (w *Worker) Healthy() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.lastErr != nil {
+		return w.lastErr
+	}
+	if !w.running {
+		return fmt.Errorf("worker %s is not running", w.Name)
+	}
+	return nil
+}
+
+func (w *Worker) setRunning(running bool, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.running = running
+	w.lastErr = err
+}
+
+func (w *Worker) loop() {
+	defer close(w.done)
+	failures := 0
+	for {
+		err := w.runOnce()
+
+		select {
+		case <-w.stop:
+			w.setRunning(false, nil)
+			w.log.WithValue("error", err).Info("worker stopped")
+			return
+		default:
+		}
+
+		if err != nil {
+			failures++
+			w.failures.Inc(1)
+			_ = w.log.WithValue("failures", failures).Error(err, "worker failed")
+		} else {
+			failures = 0
+		}
+
+		delay, restart := w.policy(err, failures)
+		if !restart {
+			w.setRunning(false, err)
+			w.log.WithValue("error", err).Info("worker finished")
+			return
+		}
+		w.setRunning(true, err)
+
+		w.log.WithFields(cue.Fields{"delay": delay, "failures": failures}).Info("restarting worker")
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-w.stop:
+			timer.Stop()
+			w.setRunning(false, nil)
+			return
+		}
+		w.restarts.Inc(1)
+		w.setRunning(true, nil)
+	}
+}
+
+// runOnce calls Run and converts a panic into an error
+func (w *Worker) runOnce() (err error) {
+	defer func() {
+		if cause := recover(); cause != nil {
+			w.log.ReportRecovery(cause, "worker panicked")
+			err = fmt.Errorf("worker %s panicked: %v", w.Name, cause)
+		}
+	}()
+	return w.runnable.Run()
+}
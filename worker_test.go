@@ -0,0 +1,183 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/require"
+)
+
+type testRunnable struct {
+	runs    int32
+	results []func() error
+	stopped chan struct{}
+}
+
+func (r *testRunnable) Run() error {
+	i := int(atomic.AddInt32(&r.runs, 1)) - 1
+	if i < len(r.results) {
+		return r.results[i]()
+	}
+	<-r.stopped
+	return nil
+}
+
+func (r *testRunnable) Stop(os.Signal) error {
+	close(r.stopped)
+	return nil
+}
+
+func waitForRuns(t *testing.T, r *testRunnable, runs int32) {
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&r.runs) < runs {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d runs, got %d", runs, atomic.LoadInt32(&r.runs))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWorkerRestartsOnFailure(t *testing.T) {
+	rn := &testRunnable{
+		results: []func() error{
+			func() error { return errors.New("failed") },
+			func() error { panic("boom") },
+		},
+		stopped: make(chan struct{}),
+	}
+	mr := metrics.NewRegistry()
+	w := NewWorker("test", rn, RestartOnFailure(time.Millisecond), mr)
+	require.NoError(t, w.Init())
+
+	waitForRuns(t, rn, 3)
+	require.NoError(t, w.Healthy())
+	require.Equal(t, int64(2), metrics.GetOrRegisterCounter("go_service,worker=test worker_restarts", mr).Count())
+	require.Equal(t, int64(2), metrics.GetOrRegisterCounter("go_service,worker=test worker_failures", mr).Count())
+
+	w.Shutdown(nil)
+	require.Error(t, w.Healthy())
+	require.Equal(t, int32(3), atomic.LoadInt32(&rn.runs))
+}
+
+func TestWorkerFinishesWithoutRestart(t *testing.T) {
+	rn := &testRunnable{
+		results: []func() error{
+			func() error { return nil },
+		},
+		stopped: make(chan struct{}),
+	}
+	w := NewWorker("test", rn, RestartOnFailure(time.Millisecond), metrics.NewRegistry())
+	require.NoError(t, w.Init())
+	<-w.done
+	require.Equal(t, int32(1), atomic.LoadInt32(&rn.runs))
+	require.EqualError(t, w.Healthy(), "worker test is not running")
+}
+
+func TestWorkerReportsFailureIfNotRestarted(t *testing.T) {
+	rn := &testRunnable{
+		results: []func() error{
+			func() error { panic("boom") },
+		},
+		stopped: make(chan struct{}),
+	}
+	never := func(err error, failures int) (time.Duration, bool) { return 0, false }
+	w := NewWorker("test", rn, never, metrics.NewRegistry())
+	require.NoError(t, w.Init())
+	<-w.done
+	require.EqualError(t, w.Healthy(), "worker test panicked: boom")
+}
+
+// blockingRunnable can't be stopped, its Run returns once release is closed
+type blockingRunnable struct {
+	release chan struct{}
+}
+
+func (r *blockingRunnable) Run() error {
+	<-r.release
+	return nil
+}
+
+func TestWorkerStop(t *testing.T) {
+	t.Run("waits for shutdownable runnables", func(t *testing.T) {
+		rn := &testRunnable{stopped: make(chan struct{})}
+		w := NewWorker("test", rn, RestartAlways(time.Millisecond), metrics.NewRegistry())
+		require.NoError(t, w.Start(context.Background()))
+		waitForRuns(t, rn, 1)
+
+		require.NoError(t, w.Stop(context.Background()))
+		// stopping twice neither panics nor stops the runnable again
+		require.NoError(t, w.Stop(context.Background()))
+		w.Shutdown(nil)
+	})
+
+	t.Run("waits for other runnables until the deadline", func(t *testing.T) {
+		rn := &blockingRunnable{release: make(chan struct{})}
+		w := NewWorker("test", rn, RestartAlways(time.Millisecond), metrics.NewRegistry())
+		require.NoError(t, w.Start(context.Background()))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		require.EqualError(t, w.Stop(ctx), "worker test did not stop: context deadline exceeded")
+
+		// Run is not restarted once it returns
+		close(rn.release)
+		require.NoError(t, w.Stop(context.Background()))
+		require.EqualError(t, w.Healthy(), "worker test is not running")
+	})
+}
+
+func TestWorkerStartAndStopOnce(t *testing.T) {
+	t.Run("stop without start", func(t *testing.T) {
+		rn := &testRunnable{stopped: make(chan struct{})}
+		w := NewWorker("test", rn, RestartAlways(time.Millisecond), metrics.NewRegistry())
+		require.NoError(t, w.Stop(context.Background()))
+		w.Shutdown(nil)
+
+		// a stopped worker is not started anymore
+		require.NoError(t, w.Init())
+		require.Equal(t, int32(0), atomic.LoadInt32(&rn.runs))
+	})
+
+	t.Run("init twice", func(t *testing.T) {
+		rn := &testRunnable{stopped: make(chan struct{})}
+		w := NewWorker("test", rn, RestartAlways(time.Millisecond), metrics.NewRegistry())
+		require.NoError(t, w.Init())
+		require.NoError(t, w.Init())
+		waitForRuns(t, rn, 1)
+		require.NoError(t, w.Stop(context.Background()))
+		require.Equal(t, int32(1), atomic.LoadInt32(&rn.runs))
+	})
+}
+
+func TestRestartPolicies(t *testing.T) {
+	fail := errors.New("failed")
+
+	delay, restart := RestartAlways(time.Second)(nil, 0)
+	require.True(t, restart)
+	require.Equal(t, time.Second, delay)
+
+	_, restart = RestartOnFailure(time.Second)(nil, 0)
+	require.False(t, restart)
+	_, restart = RestartOnFailure(time.Second)(fail, 1)
+	require.True(t, restart)
+
+	backoff := RestartWithBackoff(time.Second, 5*time.Second)
+	_, restart = backoff(nil, 0)
+	require.False(t, restart)
+	for failures, expected := range map[int]time.Duration{
+		1: time.Second,
+		2: 2 * time.Second,
+		3: 4 * time.Second,
+		4: 5 * time.Second,
+		9: 5 * time.Second,
+	} {
+		delay, restart := backoff(fail, failures)
+		require.True(t, restart)
+		require.Equal(t, expected, delay, "failures=%d", failures)
+	}
+}